@@ -0,0 +1,340 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Unknwon/com"
+
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+var ErrBlobNotExist = errors.New("blob does not exist")
+
+const (
+	// _BLOB_DELETING is reference count of blob whose object is being deleted.
+	_BLOB_DELETING = -1
+
+	_BLOB_DELETE_RETRIES  = 50
+	_BLOB_DELETE_INTERVAL = 100 * time.Millisecond
+)
+
+// Blob represents an archive saved in storage by its SHA-256 checksum, revisions
+// with identical archives (e.g. forks and gopkg.in aliases) share one blob.
+type Blob struct {
+	ID     int64  `xorm:"pk autoincr"`
+	Sha256 string `xorm:"VARCHAR(64) UNIQUE"`
+	Size   int64
+	Storage
+	RefCount int64     // Number of revisions refer to the blob, _BLOB_DELETING when it is being deleted.
+	Created  time.Time `xorm:"CREATED"`
+}
+
+// blobKey returns storage key of blob of given checksum, blobs are
+// sharded by first two bytes of checksum to keep directories small.
+func blobKey(sha256 string) string {
+	return path.Join("cas", sha256[:2], sha256[2:4], sha256+".zip")
+}
+
+// isValid returns true if blob exists in its storage with recorded size.
+func (b *Blob) isValid() bool {
+	s, err := b.Driver()
+	if err != nil {
+		return false
+	}
+	info, err := s.Stat(blobKey(b.Sha256))
+	return err == nil && info.Size == b.Size
+}
+
+// getBlob returns blob of given checksum.
+func getBlob(sha256 string) (*Blob, error) {
+	b := &Blob{Sha256: sha256}
+	has, err := x.Get(b)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrBlobNotExist
+	}
+	return b, nil
+}
+
+// storeBlobFile saves archive at given local path to default storage as object of
+// given key, and returns the storage it ends up in. Archive stays in local when it
+// is larger than MAX_UPLOAD_SIZE or fails to be uploaded. Archive at local path is
+// kept, so that it can be saved again if the blob is deleted before it is referred.
+func storeBlobFile(localPath, key string, size int64) (Storage, error) {
+	s := defaultStorage()
+	if s != LOCAL && size <= setting.MaxUploadSize<<20 {
+		err := uploadArchive(s, key, localPath)
+		if err == nil {
+			return s, nil
+		}
+		log.Warn("Fail to save archive(%s) to %s: %v", key, s.Name(), err)
+	}
+
+	dst := path.Join(setting.ArchivePath, key)
+	if err := os.MkdirAll(path.Dir(dst), os.ModePerm); err != nil {
+		return LOCAL, err
+	}
+	os.Remove(dst)
+	if err := os.Link(localPath, dst); err == nil {
+		return LOCAL, nil
+	}
+	return LOCAL, com.Copy(localPath, dst)
+}
+
+// tryAddBlobRef saves archive at given local path as blob of given checksum and size
+// if no identical archive is saved yet, and adds a reference to the blob. It returns
+// false if the blob is being deleted or has been deleted since it is looked up.
+func tryAddBlobRef(localPath, sha256 string, size int64) (*Blob, bool, error) {
+	b, err := getBlob(sha256)
+	if err != nil && err != ErrBlobNotExist {
+		return nil, false, err
+	} else if b != nil && b.RefCount < 0 {
+		return nil, false, nil
+	}
+
+	if b != nil && b.isValid() {
+		log.Trace("Archive deduplicated: %s", sha256)
+	} else {
+		s, err := storeBlobFile(localPath, blobKey(sha256), size)
+		if err != nil {
+			return nil, false, fmt.Errorf("fail to save blob(%s): %v", sha256, err)
+		}
+
+		if b == nil {
+			b = &Blob{
+				Sha256:  sha256,
+				Size:    size,
+				Storage: s,
+			}
+			if _, err = x.Insert(b); err != nil {
+				// Another instance may have recorded it at the same time.
+				if b, err = getBlob(sha256); err != nil {
+					return nil, false, err
+				}
+			}
+		} else {
+			// Blob is lost from its storage and saved again.
+			b.Size = size
+			b.Storage = s
+			if _, err = x.Id(b.ID).Cols("size", "storage").Update(b); err != nil {
+				return nil, false, err
+			}
+			if _, err = x.Where("blob_id=?", b.ID).Cols("storage").Update(&Revision{Storage: s}); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+
+	// Blob is not referred again once its deletion starts.
+	affected, err := x.Where("id=? AND ref_count>=0", b.ID).Incr("ref_count").Update(new(Blob))
+	if err != nil {
+		return nil, false, err
+	}
+	return b, affected > 0, nil
+}
+
+// addBlobRef saves archive at given local path as blob of given checksum and size
+// if no identical archive is saved yet, and adds a reference to the blob. When the
+// blob is deleted meanwhile, it waits for the deletion and saves the archive again.
+// Archive at local path is removed once the reference is added.
+func addBlobRef(localPath, sha256 string, size int64) (*Blob, error) {
+	for i := 0; ; i++ {
+		b, ok, err := tryAddBlobRef(localPath, sha256, size)
+		if err != nil {
+			return nil, err
+		} else if ok {
+			os.Remove(localPath)
+			return b, nil
+		}
+
+		switch {
+		case i == _BLOB_DELETE_RETRIES:
+			// Instance deleting the blob may have stopped before finishing.
+			log.Warn("Blob(%s) is being deleted for too long, removing its record", sha256)
+			if _, err = x.Where("sha256=? AND ref_count<0", sha256).Delete(new(Blob)); err != nil {
+				return nil, err
+			}
+		case i > _BLOB_DELETE_RETRIES:
+			return nil, fmt.Errorf("fail to add reference to blob(%s)", sha256)
+		default:
+			time.Sleep(_BLOB_DELETE_INTERVAL)
+		}
+	}
+}
+
+// dropBlobRef drops a reference to blob of given ID, the blob is deleted
+// from its storage when no revision refers to it anymore.
+func dropBlobRef(id int64) error {
+	if _, err := x.Where("id=? AND ref_count>0", id).Decr("ref_count").Update(new(Blob)); err != nil {
+		return err
+	}
+
+	b := new(Blob)
+	has, err := x.Id(id).Get(b)
+	if err != nil {
+		return err
+	} else if !has || b.RefCount != 0 {
+		return nil
+	}
+
+	// Blob is marked as being deleted first, so that it is not referred again
+	// while its object is deleted. Conditional update makes sure it is not
+	// referred again since it is looked up.
+	affected, err := x.Where("id=? AND ref_count=0", id).Cols("ref_count").Update(&Blob{RefCount: _BLOB_DELETING})
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return nil
+	}
+
+	key := blobKey(b.Sha256)
+	os.Remove(path.Join(setting.ArchivePath, key))
+	os.Remove(path.Join(setting.ArchivePath, copyKey(key)))
+	s, err := b.Driver()
+	if err == nil {
+		err = s.Delete(key)
+	}
+	if err != nil {
+		// Blob is left unreferred to be deleted next time.
+		if _, e := x.Where("id=? AND ref_count=?", id, _BLOB_DELETING).Cols("ref_count").Update(new(Blob)); e != nil {
+			log.Error(4, "Fail to unmark blob(%d) as being deleted: %v", id, e)
+		}
+		return err
+	}
+
+	if _, err = x.Id(id).Delete(new(Blob)); err != nil {
+		return err
+	}
+	log.Info("Blob deleted (%s): %s", b.Storage.Name(), b.Sha256)
+	return nil
+}
+
+// BlobStats represents statistics of deduplicated archive storage.
+type BlobStats struct {
+	NumBlobs     int64
+	NumRevisions int64 // Revisions refer to blobs.
+	Size         int64 // Total size of blobs.
+	RefSize      int64 // Total size of archives of revisions refer to blobs.
+	SavedSize    int64
+	SavedPercent int64
+}
+
+// GetBlobStats returns statistics of deduplicated archive storage.
+func GetBlobStats() (*BlobStats, error) {
+	stats := new(BlobStats)
+	var err error
+	if stats.NumBlobs, err = x.Count(new(Blob)); err != nil {
+		return nil, err
+	} else if stats.NumRevisions, err = x.Where("blob_id>0").Count(new(Revision)); err != nil {
+		return nil, err
+	} else if stats.Size, err = x.SumInt(new(Blob), "size"); err != nil {
+		return nil, err
+	} else if stats.RefSize, err = x.Where("blob_id>0").SumInt(new(Revision), "size"); err != nil {
+		return nil, err
+	}
+
+	stats.SavedSize = stats.RefSize - stats.Size
+	if stats.RefSize > 0 {
+		stats.SavedPercent = stats.SavedSize * 100 / stats.RefSize
+	}
+	return stats, nil
+}
+
+// uploadArchives moves blobs saved in local to default storage,
+// blobs larger than MAX_UPLOAD_SIZE are left in local.
+func uploadArchives() {
+	s := defaultStorage()
+	if s == LOCAL {
+		return
+	}
+
+	blobs := make([]*Blob, 0, 10)
+	if err := x.Where("storage=? AND size<=?", LOCAL, setting.MaxUploadSize<<20).Find(&blobs); err != nil {
+		log.Error(4, "Fail to get local blobs: %v", err)
+		return
+	}
+
+	for _, b := range blobs {
+		key := blobKey(b.Sha256)
+		fpath := path.Join(setting.ArchivePath, key)
+		if err := uploadArchive(s, key, fpath); err != nil {
+			log.Error(4, "Fail to upload blob(%s): %v", b.Sha256, err)
+			continue
+		}
+
+		b.Storage = s
+		if _, err := x.Id(b.ID).Cols("storage").Update(b); err != nil {
+			log.Error(4, "Fail to update blob(%d): %v", b.ID, err)
+			continue
+		} else if _, err = x.Where("blob_id=?", b.ID).Cols("storage").Update(&Revision{Storage: s}); err != nil {
+			log.Error(4, "Fail to update revisions of blob(%d): %v", b.ID, err)
+			continue
+		}
+		os.Remove(fpath)
+		log.Info("Blob uploaded (%s): %s", s.Name(), b.Sha256)
+	}
+}
+
+// migrateArchives moves archives of revisions saved before content-addressed
+// storage into blobs, revisions without checksum are left as they are.
+func migrateArchives() {
+	revs := make([]*Revision, 0, 10)
+	if err := x.Where("blob_id=0 AND sha256!=''").Find(&revs); err != nil {
+		log.Error(4, "Fail to get revisions to migrate: %v", err)
+		return
+	}
+
+	for _, rev := range revs {
+		key, err := rev.Key()
+		if err != nil {
+			log.Error(4, "Fail to get key of revision(%d): %v", rev.ID, err)
+			continue
+		}
+		// Archive in remote storage is copied to local first.
+		localPath, err := rev.ArchivePath()
+		if err != nil {
+			log.Warn("Fail to get archive of revision(%d): %v", rev.ID, err)
+			continue
+		}
+
+		oldStorage := rev.Storage
+		b, err := addBlobRef(localPath, rev.Sha256, rev.Size)
+		if err != nil {
+			log.Error(4, "Fail to migrate archive(%s): %v", key, err)
+			continue
+		}
+		rev.BlobID = b.ID
+		rev.Storage = b.Storage
+		if _, err = x.Id(rev.ID).Cols("blob_id", "storage").Update(rev); err != nil {
+			log.Error(4, "Fail to update revision(%d): %v", rev.ID, err)
+			continue
+		}
+
+		if oldStorage != LOCAL {
+			if s, err := oldStorage.Driver(); err == nil {
+				s.Delete(key)
+			}
+		}
+		log.Info("Archive migrated: %s -> %s", key, b.Sha256)
+	}
+}
@@ -17,8 +17,6 @@ package models
 import (
 	"errors"
 	"fmt"
-	"os"
-	"path"
 	"regexp"
 
 	"github.com/gpmgo/switch/pkg/log"
@@ -45,49 +43,53 @@ type Block struct {
 	Note       string
 }
 
+// dropPackageArchives drops references of given revisions of given package to
+// their archives, failures are logged and do not stop blocking.
+func dropPackageArchives(pkg *Package, revs []*Revision) {
+	for _, rev := range revs {
+		rev.Pkg = pkg
+		if err := rev.dropArchive(); err != nil {
+			log.Error(4, "Fail to drop archive(%s@%s): %v", pkg.ImportPath, rev.Revision, err)
+		}
+	}
+	pkg.removeDerivedArchives()
+}
+
 // BlockPackage blocks given package.
-func BlockPackage(importPath, note string) (keys []string, err error) {
+func BlockPackage(importPath, note string) (err error) {
 	pkg, err := GetPakcageByPath(importPath)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	has, err := x.Where("import_path=?", pkg.ImportPath).Get(new(Block))
 	if err != nil {
-		return nil, err
+		return err
 	} else if has {
-		return nil, nil
+		return nil
 	}
 
 	sess := x.NewSession()
 	defer sess.Close()
 	if err = sess.Begin(); err != nil {
-		return nil, err
+		return err
 	}
 
-	keys = make([]string, 0, 10)
-
 	revs, err := pkg.GetRevisions()
 	if err != nil {
-		return nil, fmt.Errorf("error getting revisions(%s): %v", pkg.ImportPath, err)
+		return fmt.Errorf("error getting revisions(%s): %v", pkg.ImportPath, err)
 	}
 
-	// ext := archive.GetExtension(pkg.ImportPath)
 	for _, rev := range revs {
-		// switch rev.Storage {
-		// case QINIU:
-		// 	keys = append(keys, pkg.ImportPath+"-"+rev.Revision+ext)
-		// }
 		if _, err = sess.Id(rev.ID).Delete(new(Revision)); err != nil {
 			sess.Rollback()
-			return nil, err
+			return err
 		}
 	}
-	os.RemoveAll(path.Join(setting.ArchivePath, pkg.ImportPath))
 
 	if _, err = sess.Id(pkg.ID).Delete(new(Package)); err != nil {
 		sess.Rollback()
-		return nil, err
+		return err
 	}
 
 	b := &Block{
@@ -96,10 +98,14 @@ func BlockPackage(importPath, note string) (keys []string, err error) {
 	}
 	if _, err = sess.Insert(b); err != nil {
 		sess.Rollback()
-		return nil, err
+		return err
 	}
 
-	return keys, sess.Commit()
+	if err = sess.Commit(); err != nil {
+		return err
+	}
+	dropPackageArchives(pkg, revs)
+	return nil
 }
 
 // ListBlockedPackages returns a list of block rules with given offset.
@@ -180,18 +186,16 @@ func IsPackageBlocked(path string) (bool, error, error) {
 }
 
 // RunBlockRule applies given block rule to all packages.
-func RunBlockRule(id int64) (count int64, keys []string, err error) {
+func RunBlockRule(id int64) (count int64, err error) {
 	r, err := GetBlockRuleByID(id)
 	if err != nil {
-		return 0, nil, err
+		return 0, err
 	}
 	exp, err := regexp.Compile(r.Rule)
 	if err != nil {
-		return 0, nil, err
+		return 0, err
 	}
 
-	keys = make([]string, 0, 10)
-
 	err = x.Iterate(new(Package), func(idx int, bean interface{}) error {
 		pkg := bean.(*Package)
 
@@ -205,18 +209,12 @@ func RunBlockRule(id int64) (count int64, keys []string, err error) {
 		}
 
 		// Delete package archives.
-		// ext := archive.GetExtension(pkg.ImportPath)
 		for _, rev := range revs {
-			// switch rev.Storage {
-			// case QINIU:
-			// 	keys = append(keys, pkg.ImportPath+"-"+rev.Revision+ext)
-			// }
-
 			if _, err = x.Id(rev.ID).Delete(new(Revision)); err != nil {
 				return fmt.Errorf("error deleting revision(%s-%s): %v", pkg.ImportPath, rev.Revision, err)
 			}
 		}
-		os.RemoveAll(path.Join(setting.ArchivePath, pkg.ImportPath))
+		dropPackageArchives(pkg, revs)
 
 		if setting.ProdMode {
 			if _, err = x.Id(pkg.ID).Delete(new(Package)); err != nil {
@@ -230,5 +228,5 @@ func RunBlockRule(id int64) (count int64, keys []string, err error) {
 		return nil
 	})
 
-	return count, keys, err
+	return count, err
 }
@@ -0,0 +1,253 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package models
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gpmgo/switch/pkg/archive"
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+// _EVICT_BATCH_SIZE is the number of revisions evicted before usage is checked again.
+const _EVICT_BATCH_SIZE = 20
+
+// CacheStats represents statistics of evictions since the process started.
+type CacheStats struct {
+	NumEvicted int64 // Evicted because of size limit.
+	NumExpired int64 // Deleted because of age limit.
+	LastEvict  time.Time
+}
+
+var cacheStats = struct {
+	sync.RWMutex
+	CacheStats
+}{}
+
+// countEviction counts a revision evicted because of size limit.
+func countEviction() {
+	cacheStats.Lock()
+	cacheStats.NumEvicted++
+	cacheStats.LastEvict = time.Now()
+	cacheStats.Unlock()
+}
+
+// countExpiration counts a revision deleted because of age limit.
+func countExpiration() {
+	cacheStats.Lock()
+	cacheStats.NumExpired++
+	cacheStats.Unlock()
+}
+
+// GetCacheStats returns statistics of evictions since the process started.
+func GetCacheStats() CacheStats {
+	cacheStats.RLock()
+	defer cacheStats.RUnlock()
+	return cacheStats.CacheStats
+}
+
+// AccessRevision records given revision is downloaded now, which does not
+// bump the update time that expiration of revision is based on.
+func AccessRevision(r *Revision) error {
+	r.Accessed = time.Now()
+	_, err := x.Id(r.ID).NoAutoTime().Cols("accessed").Update(r)
+	return err
+}
+
+// GetCacheUsage returns total size of files under archive path, which are blobs,
+// archives saved before content-addressed storage, archives derived from them
+// (module zips and subdirectory archives) and local copies of archives in other storage.
+func GetCacheUsage() (int64, error) {
+	if _, err := os.Stat(setting.ArchivePath); err != nil {
+		return 0, err
+	}
+	_, total := listLocalFiles(setting.ArchivePath, nil)
+	return total, nil
+}
+
+// isDerivedFile returns true if file of given path relative to archive path can be
+// made again from archive of its revision, which is a module zip, a subdirectory
+// archive or a local copy of archive in other storage.
+func isDerivedFile(rel string) bool {
+	dirs := strings.Split(rel, "/")
+	if dirs[0] == _COPY_DIR {
+		return true
+	}
+	for _, dir := range dirs[:len(dirs)-1] {
+		if dir == "@v" || archive.IsSHA(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// evictDerivedFiles deletes derived files from least recently used until given usage
+// is under the low watermark, and returns usage after eviction.
+func evictDerivedFiles(usage int64) int64 {
+	files, _ := listLocalFiles(setting.ArchivePath, isDerivedFile)
+	now := time.Now()
+	for _, f := range files {
+		if usage <= setting.CacheLowWatermark || now.Sub(f.used) < _FILE_IN_USE {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			log.Error(4, "Fail to evict file(%s): %v", f.path, err)
+			continue
+		}
+		usage -= f.size
+		log.Trace("Derived file evicted: %s", f.path)
+	}
+	return usage
+}
+
+// evictRevision deletes given revision because of size limit.
+func evictRevision(rev *Revision) error {
+	if err := deleteRevision(rev); err != nil {
+		return err
+	}
+	countEviction()
+	log.Info("Revision evicted (last access: %s): %s@%s",
+		rev.Accessed.Format(time.RFC3339), rev.Pkg.ImportPath, rev.Revision)
+	return nil
+}
+
+// evictBlob evicts all revisions refer to blob of given ID, so the blob is deleted.
+// It returns false without evicting any if one of them is retained.
+func evictBlob(id int64) (bool, error) {
+	revs := make([]*Revision, 0, 2)
+	if err := x.Where("blob_id=?", id).Find(&revs); err != nil {
+		return false, err
+	}
+	for _, rev := range revs {
+		if ok, err := isRetained(rev); err != nil {
+			return false, err
+		} else if ok {
+			return false, nil
+		}
+	}
+	for _, rev := range revs {
+		if err := evictRevision(rev); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// evictRevisions frees space under archive path when cache usage exceeds MAX_SIZE
+// of [cache], until usage is under the low watermark. Derived files are evicted first
+// since they are cheap to make again, then revisions from least recently downloaded.
+// Pinned revisions and revisions kept by retention rules are never evicted.
+func evictRevisions() {
+	if setting.CacheMaxSize == 0 {
+		return
+	}
+
+	usage, err := GetCacheUsage()
+	if err != nil {
+		log.Error(4, "Fail to get cache usage: %v", err)
+		return
+	} else if usage <= setting.CacheMaxSize {
+		return
+	}
+
+	// Only one of instances share same database does eviction.
+	if ok, err := tryAcquireLease("evict"); err != nil {
+		log.Error(4, "Fail to acquire lease(evict): %v", err)
+		return
+	} else if !ok {
+		return
+	}
+	defer releaseLease("evict")
+
+	log.Info("Cache usage %d exceeds limit %d, start evicting", usage, setting.CacheMaxSize)
+	usage = evictDerivedFiles(usage)
+
+	// Evicting a revision whose blob is shared with other revisions frees nothing,
+	// such blobs are evicted along with all their revisions after other revisions,
+	// in order of their least recently downloaded revisions.
+	shared := make([]int64, 0, 10)
+	isShared := make(map[int64]bool)
+	skipped := 0 // Retained revisions and revisions of shared blobs are skipped by offset.
+	for usage > setting.CacheLowWatermark {
+		revs := make([]*Revision, 0, _EVICT_BATCH_SIZE)
+		if err = x.Where("storage=? AND pinned=?", LOCAL, false).Asc("accessed", "updated").
+			Limit(_EVICT_BATCH_SIZE, skipped).Find(&revs); err != nil {
+			log.Error(4, "Fail to get revisions to evict: %v", err)
+			return
+		} else if len(revs) == 0 {
+			break
+		}
+
+		for _, rev := range revs {
+			if ok, err := isRetained(rev); err != nil {
+				log.Error(4, "Fail to check retention of revision(%d): %v", rev.ID, err)
+				return
+			} else if ok {
+				skipped++
+				continue
+			}
+
+			if rev.BlobID > 0 {
+				refs, err := x.Where("blob_id=?", rev.BlobID).Count(new(Revision))
+				if err != nil {
+					log.Error(4, "Fail to count revisions of blob(%d): %v", rev.BlobID, err)
+					return
+				} else if refs > 1 {
+					if !isShared[rev.BlobID] {
+						isShared[rev.BlobID] = true
+						shared = append(shared, rev.BlobID)
+					}
+					skipped++
+					continue
+				}
+			}
+
+			if err = evictRevision(rev); err != nil {
+				log.Error(4, "Fail to evict revision(%d): %v", rev.ID, err)
+				return
+			}
+		}
+
+		if usage, err = GetCacheUsage(); err != nil {
+			log.Error(4, "Fail to get cache usage: %v", err)
+			return
+		}
+	}
+
+	for _, id := range shared {
+		if usage <= setting.CacheLowWatermark {
+			break
+		}
+		if ok, err := evictBlob(id); err != nil {
+			log.Error(4, "Fail to evict blob(%d): %v", id, err)
+			return
+		} else if !ok {
+			continue
+		}
+		if usage, err = GetCacheUsage(); err != nil {
+			log.Error(4, "Fail to get cache usage: %v", err)
+			return
+		}
+	}
+
+	if usage > setting.CacheLowWatermark {
+		log.Warn("Cache usage %d is still over low watermark, rest revisions are retained", usage)
+	}
+	log.Info("Cache usage after eviction: %d", usage)
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package models
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/setting"
+	"github.com/gpmgo/switch/pkg/storage"
+)
+
+// _COPY_DIR is the directory under archive path that archives in other
+// storage are copied to when they have to be read (e.g. by module proxy).
+const _COPY_DIR = ".copies"
+
+// _FILE_IN_USE is how long a copy or derived archive is considered in use
+// after last access, which is never pruned or evicted.
+const _FILE_IN_USE = time.Minute
+
+// copyKey returns local storage key of copy of archive of given key.
+func copyKey(key string) string {
+	return path.Join(_COPY_DIR, key)
+}
+
+// touchLocalFile records given copy or derived archive is used now,
+// which are pruned from least recently used.
+func touchLocalFile(fpath string) {
+	now := time.Now()
+	os.Chtimes(fpath, now, now)
+}
+
+// localFile represents a file under archive path.
+type localFile struct {
+	path string
+	size int64
+	used time.Time // Last modified or accessed.
+}
+
+// listLocalFiles returns files under given directory that match given function,
+// from least recently used, and total size of them. All files match if function
+// is nil, it receives path relative to given directory.
+func listLocalFiles(root string, match func(rel string) bool) ([]*localFile, int64) {
+	files := make([]*localFile, 0, 10)
+	var total int64
+	filepath.Walk(root, func(fpath string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		if match != nil {
+			rel, err := filepath.Rel(root, fpath)
+			if err != nil || !match(filepath.ToSlash(rel)) {
+				return nil
+			}
+		}
+		files = append(files, &localFile{fpath, fi.Size(), fi.ModTime()})
+		total += fi.Size()
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].used.Before(files[j].used)
+	})
+	return files, total
+}
+
+// pruneArchiveCopies deletes local copies of archives that are not used for COPY_TTL
+// of [storage], and least recently used ones until total size is under COPY_MAX_SIZE.
+func pruneArchiveCopies() {
+	copies, total := listLocalFiles(path.Join(setting.ArchivePath, _COPY_DIR), nil)
+	now := time.Now()
+	for _, c := range copies {
+		if now.Sub(c.used) < storage.CopyTTL && total <= storage.CopyMaxSize {
+			break
+		} else if now.Sub(c.used) < _FILE_IN_USE {
+			log.Warn("Local copies of archives take %d bytes, which are all in use", total)
+			break
+		}
+
+		if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+			log.Error(4, "Fail to remove copy of archive(%s): %v", c.path, err)
+			continue
+		}
+		total -= c.size
+		log.Trace("Copy of archive pruned: %s", c.path)
+	}
+}
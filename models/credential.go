@@ -0,0 +1,182 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gpmgo/switch/pkg/archive"
+	"github.com/gpmgo/switch/pkg/base"
+	"github.com/gpmgo/switch/pkg/log"
+)
+
+var (
+	ErrInvalidCredentialType = errors.New("Invalid credential type")
+	ErrPackagePrivate        = errors.New("package is private")
+)
+
+// Credential represents a credential to access private repositories
+// of import paths with given host and path prefix.
+type Credential struct {
+	ID         int64 `xorm:"pk autoincr"`
+	Host       string
+	PathPrefix string
+	Type       archive.CredentialType
+	Username   string
+	Secret     string `xorm:"TEXT"` // Encrypted.
+	Note       string
+	Created    time.Time `xorm:"CREATED"`
+}
+
+// Prefix returns import path prefix the credential applies to.
+func (c *Credential) Prefix() string {
+	if len(c.PathPrefix) == 0 {
+		return c.Host + "/"
+	}
+	return c.Host + "/" + strings.Trim(c.PathPrefix, "/")
+}
+
+// Match returns true if credential applies to given import path.
+func (c *Credential) Match(importPath string) bool {
+	prefix := c.Prefix()
+	return importPath == prefix || strings.HasPrefix(importPath, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// NewCredential encrypts secret and adds a new credential.
+func NewCredential(c *Credential, secret string) (err error) {
+	switch c.Type {
+	case archive.CREDENTIAL_TOKEN, archive.CREDENTIAL_BASIC, archive.CREDENTIAL_SSH:
+	default:
+		return ErrInvalidCredentialType
+	}
+
+	c.Host = strings.Trim(c.Host, "/")
+	c.PathPrefix = strings.Trim(c.PathPrefix, "/")
+	c.Secret, err = base.EncryptSecret(secret)
+	if err == base.ErrSecretKeyNotSet {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("error encrypting secret: %v", err)
+	}
+	_, err = x.Insert(c)
+	return err
+}
+
+// ListCredentials returns a list of credentials with secret encrypted.
+func ListCredentials() ([]*Credential, error) {
+	creds := make([]*Credential, 0, 10)
+	return creds, x.Asc("host").Asc("path_prefix").Find(&creds)
+}
+
+// DeleteCredential deletes a credential by given ID.
+func DeleteCredential(id int64) error {
+	_, err := x.Id(id).Delete(new(Credential))
+	return err
+}
+
+// MatchCredential returns the credential with longest prefix that applies to given
+// import path, it returns nil if no credential applies.
+func MatchCredential(importPath string) (*Credential, error) {
+	creds, err := ListCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	var match *Credential
+	for _, c := range creds {
+		if c.Match(importPath) && (match == nil || len(c.Prefix()) > len(match.Prefix())) {
+			match = c
+		}
+	}
+	return match, nil
+}
+
+// NewNode returns a node of given import path and revision,
+// and sets credential to it if there is one applies.
+func NewNode(importPath, rev string) (*archive.Node, error) {
+	n := archive.NewNode(importPath, rev)
+	cred, err := archiveCredential(n.ImportPath)
+	if err != nil {
+		return nil, err
+	}
+	n.Credential = cred
+	return n, nil
+}
+
+// archiveCredential returns decrypted credential applies to given import path,
+// or nil if there is none.
+func archiveCredential(importPath string) (*archive.Credential, error) {
+	c, err := MatchCredential(importPath)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return nil, nil
+	}
+
+	secret, err := base.DecryptSecret(c.Secret)
+	if err == base.ErrSecretKeyNotSet {
+		return nil, fmt.Errorf("error decrypting credential of %s: %v", c.Prefix(), err)
+	} else if err != nil {
+		log.Error(4, "Fail to decrypt credential(%d): %v", c.ID, err)
+		return nil, fmt.Errorf("error decrypting credential of %s", c.Prefix())
+	}
+	return &archive.Credential{
+		Type:     c.Type,
+		Host:     c.Host,
+		Username: c.Username,
+		Secret:   secret,
+	}, nil
+}
+
+// IsPrivatePath returns true if given import path is of a private package,
+// or credential applies to it so it would be private once fetched.
+func IsPrivatePath(importPath string) (bool, error) {
+	pkg, err := GetPakcageByPath(importPath)
+	if err == nil {
+		return pkg.IsPrivate, nil
+	} else if err != ErrPackageNotExist {
+		return false, err
+	}
+
+	c, err := MatchCredential(importPath)
+	if err != nil {
+		return false, err
+	}
+	return c != nil, nil
+}
+
+// CheckPkgAccess is CheckPkg for clients may not be authorized to access private packages,
+// it returns ErrPackagePrivate without fetching anything for unauthorized ones.
+func CheckPkgAccess(importPath, rev string, date time.Time, authorized bool) (*Revision, error) {
+	if !authorized {
+		private, err := IsPrivatePath(importPath)
+		if err != nil {
+			return nil, err
+		} else if private {
+			return nil, ErrPackagePrivate
+		}
+	}
+
+	r, err := CheckPkg(importPath, rev, date)
+	if err != nil {
+		return nil, err
+	} else if r.Pkg.IsPrivate && !authorized {
+		return nil, ErrPackagePrivate
+	}
+	return r, nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package models
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gpmgo/switch/pkg/archive"
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+// TagDrift represents an event of a tag being moved or deleted upstream
+// after its revision has been cached.
+type TagDrift struct {
+	ID          int64  `xorm:"pk autoincr"`
+	PkgID       int64  `xorm:"INDEX"`
+	ImportPath  string `xorm:"UNIQUE(s)"`
+	Tag         string `xorm:"UNIQUE(s)"`
+	OldRevision string // Revision that is cached and still served.
+	NewRevision string `xorm:"UNIQUE(s)"` // Empty if the tag has been deleted.
+	IsPrivate   bool
+	Detected    time.Time `xorm:"CREATED"`
+}
+
+// ListTagDrifts returns a list of tag drift events with given offset,
+// events of private packages are excluded unless includePrivate is true.
+func ListTagDrifts(offset int, includePrivate bool) ([]*TagDrift, error) {
+	drifts := make([]*TagDrift, 0, setting.PageSize)
+	sess := x.Limit(setting.PageSize, offset).Desc("id")
+	if !includePrivate {
+		sess.Where("is_private=?", false)
+	}
+	return drifts, sess.Find(&drifts)
+}
+
+// IsDeleted returns true if the tag has been deleted rather than moved.
+func (d *TagDrift) IsDeleted() bool {
+	return len(d.NewRevision) == 0
+}
+
+// driftedTagRevision returns the originally cached revision of given tag of
+// package with given import path, or empty string if the tag has not drifted.
+func driftedTagRevision(importPath, tag string) (string, error) {
+	d := new(TagDrift)
+	has, err := x.Where("import_path=? AND tag=?", importPath, tag).Asc("id").Get(d)
+	if err != nil || !has {
+		return "", err
+	}
+	return d.OldRevision, nil
+}
+
+// pinDriftedTag sets the originally cached revision of given tag to node if the
+// tag has been moved or deleted upstream. It returns false if the tag has not drifted.
+func pinDriftedTag(n *archive.Node, tag string) (bool, error) {
+	rev, err := driftedTagRevision(n.ImportPath, tag)
+	if err != nil || len(rev) == 0 {
+		return false, err
+	} else if rev == n.Revision {
+		return true, nil
+	}
+
+	log.Trace("Pin drifted tag %s of %s: %s", tag, n.ImportPath, rev)
+	n.Revision = rev
+	n.Tag = tag
+	n.Committed = time.Time{}
+	n.Commit = nil
+	n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, rev+archive.GetExtension(n.ImportPath))
+	return true, nil
+}
+
+// pinDriftedTags replaces revisions of tags that have been moved or deleted
+// upstream in given references with the originally cached ones.
+func pinDriftedTags(importPath string, refs *archive.Refs) error {
+	drifts := make([]*TagDrift, 0, 5)
+	if err := x.Where("import_path=?", importPath).Desc("id").Find(&drifts); err != nil {
+		return err
+	}
+	if len(drifts) > 0 && refs.Tags == nil {
+		refs.Tags = make(map[string]string, len(drifts))
+	}
+	// Earliest event of a tag is applied last, which has the originally cached revision.
+	for _, d := range drifts {
+		refs.Tags[d.Tag] = d.OldRevision
+	}
+	return nil
+}
+
+// checkTagDrift re-resolves tag of given revision by given references of its package,
+// and records the event and flags the revision if the tag has been moved or deleted.
+func checkTagDrift(r *Revision, refs *archive.Refs) error {
+	sha, ok := refs.Resolve(r.Tag)
+	if ok && sha == r.Revision {
+		return nil
+	}
+
+	if ok {
+		log.Warn("Tag %s of %s has moved: %s -> %s", r.Tag, r.Pkg.ImportPath, r.Revision, sha)
+	} else {
+		log.Warn("Tag %s of %s has been deleted: %s", r.Tag, r.Pkg.ImportPath, r.Revision)
+	}
+	has, err := x.Where("import_path=? AND tag=? AND new_revision=?", r.Pkg.ImportPath, r.Tag, sha).Get(new(TagDrift))
+	if err != nil {
+		return err
+	} else if !has {
+		if _, err = x.Insert(&TagDrift{
+			PkgID:       r.PkgID,
+			ImportPath:  r.Pkg.ImportPath,
+			Tag:         r.Tag,
+			OldRevision: r.Revision,
+			NewRevision: sha,
+			IsPrivate:   r.Pkg.IsPrivate,
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Originally cached revision is kept being served.
+	r.IsTagMoved = true
+	_, err = x.Id(r.ID).Cols("is_tag_moved").Update(r)
+	return err
+}
+
+// checkPackageTagDrifts checks tag drifts of given revisions of same package,
+// references of the package are listed once for all of them. Host of the package
+// is added to paused when its rate limit is exhausted.
+func checkPackageTagDrifts(revs []*Revision, paused map[string]bool) {
+	if err := revs[0].GetPackage(); err != nil {
+		log.Error(4, "Fail to get package(%d): %v", revs[0].PkgID, err)
+		return
+	}
+	pkg := revs[0].Pkg
+	host := strings.SplitN(pkg.ImportPath, "/", 2)[0]
+	if paused[host] {
+		return
+	}
+
+	n, err := NewNode(pkg.ImportPath, "")
+	if err != nil {
+		log.Error(4, "Fail to check tag drifts of %s: %v", pkg.ImportPath, err)
+		return
+	}
+	refs, err := n.ListRefs()
+	if err != nil {
+		if _, ok := archive.RetryAfter(err); ok {
+			log.Warn("Skip checking tag drifts of %s until next check: %v", host, err)
+			paused[host] = true
+			return
+		}
+		log.Error(4, "Fail to list refs of %s: %v", pkg.ImportPath, err)
+		return
+	}
+
+	for _, r := range revs {
+		r.Pkg = pkg
+		if err = checkTagDrift(r, refs); err != nil {
+			log.Error(4, "Fail to check tag drift(%d): %v", r.ID, err)
+		}
+	}
+}
+
+// checkTagDrifts re-resolves tags of all cached revisions to detect moved ones.
+func checkTagDrifts() {
+	revs := make([]*Revision, 0, 100)
+	if err := x.Where("tag!=''").Asc("pkg_id").Find(&revs); err != nil {
+		log.Error(4, "Fail to get revisions resolved from tags: %v", err)
+		return
+	}
+
+	paused := make(map[string]bool)
+	for i := 0; i < len(revs); {
+		j := i + 1
+		for j < len(revs) && revs[j].PkgID == revs[i].PkgID {
+			j++
+		}
+		checkPackageTagDrifts(revs[i:j], paused)
+		i = j
+	}
+}
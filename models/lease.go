@@ -0,0 +1,137 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gpmgo/switch/pkg/base"
+	"github.com/gpmgo/switch/pkg/log"
+)
+
+const (
+	_LEASE_DURATION       = 5 * time.Minute
+	_LEASE_RENEW_INTERVAL = time.Minute
+	_LEASE_POLL_INTERVAL  = time.Second
+)
+
+var ErrLeaseTimeout = errors.New("timeout waiting for lease")
+
+// instanceID identifies current process among instances sharing same database.
+var instanceID = func() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), base.GetRandomString(8))
+}()
+
+// Lease represents an exclusive right of an instance to do work with given key
+// until it expires, so instances share same database do not duplicate the work.
+type Lease struct {
+	ID      int64  `xorm:"pk autoincr"`
+	Name    string `xorm:"UNIQUE"`
+	Owner   string
+	Expires int64 `xorm:"INDEX"`
+}
+
+// tryAcquireLease tries to acquire the lease with given key,
+// it returns false if the lease is held by another instance.
+func tryAcquireLease(key string) (bool, error) {
+	now := time.Now()
+	l := &Lease{
+		Name:    key,
+		Owner:   instanceID,
+		Expires: now.Add(_LEASE_DURATION).Unix(),
+	}
+	if _, err := x.Insert(l); err == nil {
+		return true, nil
+	}
+
+	// Insert fails when lease exists, take it over if it has expired.
+	// Conditional update makes sure only one instance wins the race.
+	affected, err := x.Where("name=? AND expires<?", key, now.Unix()).
+		Cols("owner", "expires").Update(&Lease{
+		Owner:   instanceID,
+		Expires: l.Expires,
+	})
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// releaseLease releases the lease with given key held by current instance.
+func releaseLease(key string) {
+	if _, err := x.Where("name=? AND owner=?", key, instanceID).Delete(new(Lease)); err != nil {
+		log.Error(4, "Fail to release lease(%s): %v", key, err)
+	}
+}
+
+// renewLease extends the lease with given key held by current instance
+// periodically until stop is closed, so it does not expire during long work.
+func renewLease(key string, stop <-chan struct{}) {
+	ticker := time.NewTicker(_LEASE_RENEW_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := x.Where("name=? AND owner=?", key, instanceID).Cols("expires").Update(&Lease{
+				Expires: time.Now().Add(_LEASE_DURATION).Unix(),
+			}); err != nil {
+				log.Error(4, "Fail to renew lease(%s): %v", key, err)
+			}
+		}
+	}
+}
+
+// withLease executes given function while holding the lease with given key,
+// unless done returns true which means the work has been done by another instance.
+// The lease is renewed until the function returns. When the lease is held by
+// another instance, it waits until the work is done or the lease is released.
+func withLease(key string, done func() bool, fn func() error) error {
+	deadline := time.Now().Add(_LEASE_DURATION)
+	for {
+		ok, err := tryAcquireLease(key)
+		if err != nil {
+			return fmt.Errorf("error acquiring lease(%s): %v", key, err)
+		} else if ok {
+			defer releaseLease(key)
+			if done() {
+				return nil
+			}
+			stop := make(chan struct{})
+			defer close(stop)
+			go renewLease(key, stop)
+			return fn()
+		}
+
+		if done() {
+			return nil
+		} else if time.Now().After(deadline) {
+			return ErrLeaseTimeout
+		}
+		log.Trace("Waiting for lease: %s", key)
+		time.Sleep(_LEASE_POLL_INTERVAL)
+	}
+}
+
+func cleanExpiredLeases() {
+	if _, err := x.Where("expires<?", time.Now().Unix()).Delete(new(Lease)); err != nil {
+		log.Error(4, "Fail to clean expired leases: %v", err)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/go-xorm/xorm"
 	"github.com/robfig/cron"
 
+	"github.com/gpmgo/switch/pkg/archive"
 	"github.com/gpmgo/switch/pkg/log"
 	"github.com/gpmgo/switch/pkg/setting"
 )
@@ -48,27 +49,30 @@ func init() {
 		x.SetLogger(xorm.NewSimpleLogger(ioutil.Discard))
 	}
 
-	if err = x.Sync2(new(Package), new(Revision), new(Downloader),
-		new(Block), new(BlockRule)); err != nil {
+	if err = x.Sync2(new(Package), new(Revision), new(Blob), new(Downloader),
+		new(Block), new(BlockRule), new(Credential), new(Lease), new(RefCache), new(RefList), new(TagDrift)); err != nil {
 		log.Fatal(4, "Fail to sync database: %v", err)
 	}
 
+	loadRetentionRules()
+	archive.CredentialOf = archiveCredential
+
 	statistic()
 	c := cron.New()
 	c.AddFunc("@every 5m", statistic)
 	c.AddFunc("@every 1h", cleanExpireRevesions)
+	c.AddFunc("@every 10m", evictRevisions)
+	c.AddFunc("@every 10m", pruneArchiveCopies)
+	c.AddFunc("@every 1h", uploadArchives)
+	c.AddFunc("@every 1h", cleanExpiredLeases)
+	c.AddFunc("@every 6h", checkTagDrifts)
 	c.Start()
 
 	go cleanExpireRevesions()
-	// if setting.ProdMode {
-	// 	go uploadArchives()
-	// 	ticker := time.NewTicker(time.Hour)
-	// 	go func() {
-	// 		for _ = range ticker.C {
-	// 			uploadArchives()
-	// 		}
-	// 	}()
-	// }
+	go func() {
+		migrateArchives()
+		uploadArchives()
+	}()
 }
 
 func Ping() error {
@@ -99,79 +103,11 @@ func statistic() {
 	Statistic.NumDownloaders, _ = x.Count(new(Downloader))
 
 	Statistic.TrendingPackages = make([]*Package, 0, 15)
-	x.Limit(15).Desc("recent_download").Find(&Statistic.TrendingPackages)
+	x.Limit(15).Where("is_private=?", false).Desc("recent_download").Find(&Statistic.TrendingPackages)
 
 	Statistic.NewPackages = make([]*Package, 0, 15)
-	x.Limit(15).Desc("created").Find(&Statistic.NewPackages)
+	x.Limit(15).Where("is_private=?", false).Desc("created").Find(&Statistic.NewPackages)
 
 	Statistic.PopularPackages = make([]*Package, 0, 15)
-	x.Limit(15).Desc("download_count").Find(&Statistic.PopularPackages)
+	x.Limit(15).Where("is_private=?", false).Desc("download_count").Find(&Statistic.PopularPackages)
 }
-
-// uploadArchives checks and uploads local archives to QiNiu.
-// func uploadArchives() {
-// 	revs, err := GetLocalRevisions()
-// 	if err != nil {
-// 		log.Error(4, "Fail to get local revisions: %v", err)
-// 		return
-// 	}
-
-// 	// Upload.
-// 	for _, rev := range revs {
-// 		pkg, err := GetPakcageByID(rev.PkgID)
-// 		if err != nil {
-// 			log.Error(4, "Fail to get package by ID(%d): %v", rev.PkgID, err)
-// 			continue
-// 		}
-
-// 		ext := archive.GetExtension(pkg.ImportPath)
-// 		key := pkg.ImportPath + "-" + rev.Revision + ext
-// 		localPath := path.Join(pkg.ImportPath, rev.Revision)
-// 		fpath := path.Join(setting.ArchivePath, localPath+ext)
-
-// 		// Move.
-// 		// rsCli := rs.New(nil)
-// 		// log.Info(key)
-// 		// err = rsCli.Move(nil, setting.BucketName, pkg.ImportPath+"-"+rev.Revision, setting.BucketName, key)
-// 		// if err != nil {
-// 		// 	log.Error(4, rev.Revision)
-// 		// }
-// 		// continue
-
-// 		if !com.IsFile(fpath) {
-// 			log.Debug("Delete: %v", fpath)
-// 			DeleteRevisionById(rev.ID)
-// 			continue
-// 		}
-
-// 		// Check archive size.
-// 		f, err := os.Open(fpath)
-// 		if err != nil {
-// 			log.Error(4, "Fail to open file(%s): %v", fpath, err)
-// 			continue
-// 		}
-// 		fi, err := f.Stat()
-// 		if err != nil {
-// 			log.Error(4, "Fail to get file info(%s): %v", fpath, err)
-// 			continue
-// 		}
-// 		// Greater then MAX_UPLOAD_SIZE.
-// 		if fi.Size() > setting.MaxUploadSize<<20 {
-// 			log.Debug("Ignore large archive: %v", fpath)
-// 			continue
-// 		}
-
-// 		log.Debug("Uploading: %s", localPath)
-// 		if err = qiniu.UploadArchive(key, fpath); err != nil {
-// 			log.Error(4, "Fail to upload file(%s): %v", fpath, err)
-// 			continue
-// 		}
-// 		rev.Storage = QINIU
-// 		if err := UpdateRevision(rev); err != nil {
-// 			log.Error(4, "Fail to upadte revision(%d): %v", rev.ID, err)
-// 			continue
-// 		}
-// 		os.Remove(fpath)
-// 		log.Info("Uploaded: %s", localPath)
-// 	}
-// }
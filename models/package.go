@@ -16,31 +16,67 @@ package models
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path"
 	"strings"
 	"time"
 
-	"github.com/Unknwon/com"
-
 	"github.com/gpmgo/switch/pkg/archive"
 	"github.com/gpmgo/switch/pkg/log"
 	"github.com/gpmgo/switch/pkg/setting"
+	"github.com/gpmgo/switch/pkg/singleflight"
+	"github.com/gpmgo/switch/pkg/storage"
 )
 
 var (
-	ErrRevisionIsLocal  = errors.New("revision archive is in local")
 	ErrPackageNotExist  = errors.New("package does not exist")
 	ErrRevisionNotExist = errors.New("revision does not exist")
 )
 
+// Storage represents the storage that archive of a revision is saved in.
 type Storage int
 
 const (
 	LOCAL Storage = iota
-	// QINIU
+	S3
 )
 
+var storageNames = map[Storage]string{
+	LOCAL: storage.LOCAL,
+	S3:    storage.S3,
+}
+
+// Name returns name of storage driver.
+func (s Storage) Name() string {
+	return storageNames[s]
+}
+
+// Driver returns driver of the storage.
+func (s Storage) Driver() (storage.Storage, error) {
+	d := storage.Get(s.Name())
+	if d == nil {
+		return nil, fmt.Errorf("storage '%s' is not configured", s.Name())
+	}
+	return d, nil
+}
+
+// defaultStorage returns the storage that new archives are saved in.
+func defaultStorage() Storage {
+	for s, name := range storageNames {
+		if name == storage.Default {
+			return s
+		}
+	}
+	return LOCAL
+}
+
+// archiveKey returns storage key of archive of given revision of given package
+// that is saved before content-addressed storage.
+func archiveKey(importPath, rev string) string {
+	return importPath + "/" + rev + archive.GetExtension(importPath)
+}
+
 // Revision represents a revision of a Go package.
 type Revision struct {
 	ID       int64    `xorm:"pk autoincr"`
@@ -48,8 +84,74 @@ type Revision struct {
 	Pkg      *Package `xorm:"-"`
 	Revision string   `xorm:"UNIQUE(s)"`
 	Storage
-	Size    int64
-	Updated time.Time `xorm:"UPDATED"`
+	Size          int64
+	Sha256        string    `xorm:"VARCHAR(64)"`
+	BlobID        int64     `xorm:"INDEX"` // Blob of archive, 0 if saved before content-addressed storage.
+	Tag           string    // Tag the revision is resolved from, empty if not from a tag.
+	IsTagMoved    bool      // Tag has been moved to another revision or deleted upstream.
+	Committed     time.Time `xorm:"INDEX"`
+	Author        string
+	Subject       string    // First line of commit message.
+	Refs          string    `xorm:"TEXT"` // References the revision is requested with, one per line.
+	PseudoVersion string    // Go pseudo-version, empty if tagged with a semantic version or unknown.
+	Accessed      time.Time `xorm:"INDEX"`     // Last time the revision is downloaded.
+	Pinned        bool      `xorm:"DEFAULT 0"` // Never expires or gets evicted.
+	Updated       time.Time `xorm:"UPDATED"`
+}
+
+// _MAX_REVISION_REFS is the maximum number of references recorded for a revision.
+const _MAX_REVISION_REFS = 20
+
+// RefNames returns references the revision is requested with,
+// "HEAD" stands for default branch.
+func (r *Revision) RefNames() []string {
+	if len(r.Refs) == 0 {
+		return []string{}
+	}
+	return strings.Split(r.Refs, "\n")
+}
+
+// addRef records given reference the revision is requested with.
+func (r *Revision) addRef(ref string) {
+	if len(ref) == 0 {
+		ref = "HEAD"
+	}
+	refs := r.RefNames()
+	for _, name := range refs {
+		if name == ref {
+			return
+		}
+	}
+	refs = append(refs, ref)
+	if len(refs) > _MAX_REVISION_REFS {
+		refs = refs[len(refs)-_MAX_REVISION_REFS:]
+	}
+	r.Refs = strings.Join(refs, "\n")
+}
+
+// setCommit sets commit metadata of revision.
+func (r *Revision) setCommit(c *archive.Commit) {
+	r.Committed = c.Time
+	r.Author = c.Author
+	r.Subject = c.Subject
+}
+
+// isArchiveValid returns true if archive of the revision exists in its
+// storage and has the size recorded when it was downloaded.
+func (r *Revision) isArchiveValid() bool {
+	key, err := r.Key()
+	if err != nil {
+		return false
+	}
+	s, err := r.Driver()
+	if err != nil {
+		return false
+	}
+	info, err := s.Stat(key)
+	if err != nil {
+		return false
+	}
+	return r.Size == 0 || info.Size == r.Size
 }
 
 func (r *Revision) GetPackage() (err error) {
@@ -60,15 +162,100 @@ func (r *Revision) GetPackage() (err error) {
 	return err
 }
 
-// KeyName returns QiNiu key name.
-func (r *Revision) KeyName() (string, error) {
+// Key returns storage key of archive of the revision.
+func (r *Revision) Key() (string, error) {
+	if r.BlobID > 0 {
+		return blobKey(r.Sha256), nil
+	}
+	if err := r.GetPackage(); err != nil {
+		return "", err
+	}
+	return archiveKey(r.Pkg.ImportPath, r.Revision), nil
+}
+
+// ArchivePath returns path of archive of the revision in local, archive saved in
+// other storage is copied to local first. Copies are pruned when they are not used
+// for a while, so the path should not be kept.
+func (r *Revision) ArchivePath() (string, error) {
+	key, err := r.Key()
+	if err != nil {
+		return "", err
+	}
 	if r.Storage == LOCAL {
-		return "", ErrRevisionIsLocal
+		return path.Join(setting.ArchivePath, key), nil
+	}
+
+	archivePath := path.Join(setting.ArchivePath, copyKey(key))
+	if fi, err := os.Stat(archivePath); err == nil && (r.Size == 0 || fi.Size() == r.Size) {
+		touchLocalFile(archivePath)
+		return archivePath, nil
 	}
+	_, err, _ = fetchGroup.Do("copy:"+archivePath, func() (interface{}, error) {
+		s, err := r.Driver()
+		if err != nil {
+			return nil, err
+		}
+		rc, info, err := s.Open(key)
+		if err != nil {
+			return nil, fmt.Errorf("fail to open archive(%s): %v", key, err)
+		}
+		defer rc.Close()
+		return nil, storage.Get(storage.LOCAL).Put(copyKey(key), rc, info.Size)
+	})
+	if err != nil {
+		return "", err
+	}
+	return archivePath, nil
+}
+
+// dropArchive drops reference of the revision to its archive, along with archives
+// extracted from it. Archive saved before content-addressed storage is deleted.
+func (r *Revision) dropArchive() error {
 	if err := r.GetPackage(); err != nil {
+		return err
+	}
+	// Extracted subdirectory archives.
+	os.RemoveAll(path.Join(setting.ArchivePath, r.Pkg.ImportPath, r.Revision))
+
+	if r.BlobID > 0 {
+		return dropBlobRef(r.BlobID)
+	}
+
+	key := archiveKey(r.Pkg.ImportPath, r.Revision)
+	os.Remove(path.Join(setting.ArchivePath, key))
+	os.Remove(path.Join(setting.ArchivePath, copyKey(key)))
+	s, err := r.Driver()
+	if err != nil {
+		return err
+	}
+	return s.Delete(key)
+}
+
+// SubdirArchivePath returns path of archive that only contains given subdirectory of
+// the revision, which is extracted from archive of the revision and cached next to it.
+func (r *Revision) SubdirArchivePath(subdir string) (string, error) {
+	if err := archive.CheckSubdir(subdir); err != nil {
+		return "", err
+	} else if err = r.GetPackage(); err != nil {
+		return "", err
+	}
+	archivePath, err := r.ArchivePath()
+	if err != nil {
+		return "", err
+	}
+
+	subPath := path.Join(setting.ArchivePath, r.Pkg.ImportPath, r.Revision, subdir+".zip")
+	if _, err = os.Stat(subPath); err == nil {
+		touchLocalFile(subPath)
+		return subPath, nil
+	}
+	_, err, _ = fetchGroup.Do("extract:"+subPath, func() (interface{}, error) {
+		return nil, archive.ExtractSubdir(archivePath, subPath, subdir)
+	})
+	if err != nil {
 		return "", err
 	}
-	return r.Pkg.ImportPath + "-" + r.Revision + archive.GetExtension(r.Pkg.ImportPath), nil
+	return subPath, nil
 }
 
 // GetRevision returns revision by given pakcage ID and revision.
@@ -86,6 +273,25 @@ func GetRevision(pkgID int64, rev string) (*Revision, error) {
 	return r, nil
 }
 
+// GetRevisionByID returns revision by given ID.
+func GetRevisionByID(id int64) (*Revision, error) {
+	r := new(Revision)
+	has, err := x.Id(id).Get(r)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrRevisionNotExist
+	}
+	return r, nil
+}
+
+// ListRevisions returns a list of revisions with given offset,
+// sorted by last update from newest.
+func ListRevisions(offset int) ([]*Revision, error) {
+	revs := make([]*Revision, 0, setting.PageSize)
+	return revs, x.Limit(setting.PageSize, offset).Desc("updated").Find(&revs)
+}
+
 // UpdateRevision updates revision information.
 func UpdateRevision(rev *Revision) error {
 	_, err := x.Id(rev.ID).Update(rev)
@@ -105,10 +311,11 @@ func GetLocalRevisions() ([]*Revision, error) {
 	return revs, err
 }
 
-// GetRevisionsByPkgId returns a list of revisions of given package ID.
+// GetRevisionsByPkgId returns a list of revisions of given package ID,
+// sorted by commit time from newest.
 func GetRevisionsByPkgId(pkgId int64) ([]*Revision, error) {
 	revs := make([]*Revision, 0, 10)
-	err := x.Where("pkg_id=?", pkgId).Find(&revs)
+	err := x.Where("pkg_id=?", pkgId).Desc("committed", "updated").Find(&revs)
 	return revs, err
 }
 
@@ -122,6 +329,7 @@ type Package struct {
 	DownloadCount  int64
 	RecentDownload int64
 	IsValidated    bool      `xorm:"DEFAULT 0"`
+	IsPrivate      bool      `xorm:"DEFAULT 0"` // Fetched with credential.
 	Created        time.Time `xorm:"CREATED"`
 }
 
@@ -129,6 +337,13 @@ func (pkg *Package) GetRevisions() ([]*Revision, error) {
 	return GetRevisionsByPkgId(pkg.ID)
 }
 
+// removeDerivedArchives removes archives derived from archives of the package,
+// e.g. module zips. Directory of the package does not contain blobs, which are
+// shared with other packages and only deleted by dropping references.
+func (pkg *Package) removeDerivedArchives() {
+	os.RemoveAll(path.Join(setting.ArchivePath, pkg.ImportPath))
+}
+
 // NewPackage creates
 func NewPackage(importPath string) (*Package, error) {
 	pkg := &Package{
@@ -167,7 +382,8 @@ func GetPakcageByPath(importPath string) (*Package, error) {
 }
 
 // CheckPkg checks if versioned package is in records, and download it when needed.
-func CheckPkg(importPath, rev string) (*Revision, error) {
+// When date is not zero, rev is resolved to the last commit at or before the date.
+func CheckPkg(importPath, rev string, date time.Time) (*Revision, error) {
 	// Check package record.
 	pkg, err := GetPakcageByPath(importPath)
 	if err != nil {
@@ -182,34 +398,122 @@ func CheckPkg(importPath, rev string) (*Revision, error) {
 		}
 	}
 
-	n := archive.NewNode(importPath, rev)
+	n, err := NewNode(importPath, rev)
+	if err != nil {
+		return nil, err
+	}
+	n.Date = date
 
 	// Get and check revision record.
-	if err = n.GetRevision(); err != nil {
+	if err = ResolveRevision(n); err != nil {
 		return nil, err
 	}
 
-	var r *Revision
+	// Root path of vanity import path is only known after dynamic discovery.
+	if n.ImportPath != importPath {
+		pkg, err = GetPakcageByPath(n.ImportPath)
+		if err != nil {
+			if err != ErrPackageNotExist {
+				return nil, err
+			}
+			blocked, blockErr, err := IsPackageBlocked(n.ImportPath)
+			if err != nil {
+				return nil, err
+			} else if blocked {
+				return nil, blockErr
+			}
+		}
+	}
+
+	// Concurrent requests of same revision are coalesced into one fetch.
+	v, err, _ := fetchGroup.Do(n.ImportPath+"@"+n.Revision, func() (interface{}, error) {
+		return fetchRevision(pkg, n)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Revision), nil
+}
+
+var fetchGroup singleflight.Group
+
+// isRevisionFetched returns true if archive of given revision has been downloaded and recorded.
+func isRevisionFetched(importPath, rev string) bool {
+	pkg, err := GetPakcageByPath(importPath)
+	if err != nil {
+		return false
+	}
+	r, err := GetRevision(pkg.ID, rev)
+	if err != nil {
+		return false
+	}
+	r.Pkg = pkg
+	return r.isArchiveValid()
+}
+
+// fetchRevision downloads archive of node when needed, and records package and revision.
+func fetchRevision(pkg *Package, n *archive.Node) (r *Revision, err error) {
+	// Package may have been created by a fetch finished since it was looked up.
+	if pkg == nil {
+		if pkg, err = GetPakcageByPath(n.ImportPath); err != nil && err != ErrPackageNotExist {
+			return nil, err
+		}
+	}
 	if pkg != nil {
 		r, err = GetRevision(pkg.ID, n.Revision)
 		if err != nil && err != ErrRevisionNotExist {
 			return nil, err
+		} else if r != nil {
+			r.Pkg = pkg
 		}
 	}
 
-	// return nil, fmt.Errorf("Revision: %s", n.Revision)
+	if r != nil && r.isArchiveValid() {
+		return recordRevision(pkg, r, n)
+	}
 
-	// FIXME: Fallback to LOCAL only mode at the moment, should work out a solution to another OSS.
-	// if r == nil || (r.Storage == LOCAL && !com.IsFile(n.ArchivePath)) {
-	if r == nil || !com.IsFile(n.ArchivePath) {
+	// Lease stops other instances share same database and storage from downloading,
+	// it is held until the revision is recorded so they do not see a missing row.
+	recorded := false
+	if err = withLease("download:"+n.ImportPath+"@"+n.Revision, func() bool {
+		return isRevisionFetched(n.ImportPath, n.Revision)
+	}, func() error {
 		if err := n.Download(); err != nil {
-			return nil, err
+			return err
 		}
+		r, err = recordRevision(pkg, r, n)
+		recorded = err == nil
+		return err
+	}); err != nil {
+		return nil, err
+	} else if recorded {
+		return r, nil
+	}
+
+	// Archive is downloaded and recorded by another instance.
+	if pkg, err = GetPakcageByPath(n.ImportPath); err != nil {
+		return nil, err
+	} else if r, err = GetRevision(pkg.ID, n.Revision); err != nil {
+		return nil, err
 	}
+	return recordRevision(pkg, r, n)
+}
 
+// recordRevision records package and revision of node, and the archive if it is
+// downloaded this time. Package and revision are nil if they do not exist.
+func recordRevision(pkg *Package, r *Revision, n *archive.Node) (*Revision, error) {
+	var err error
 	if pkg == nil {
-		pkg, err = NewPackage(n.ImportPath)
-		if err != nil {
+		if pkg, err = NewPackage(n.ImportPath); err != nil {
+			// Another instance may have created it at the same time.
+			if pkg, err = GetPakcageByPath(n.ImportPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if n.Credential != nil && !pkg.IsPrivate {
+		pkg.IsPrivate = true
+		if _, err = x.Id(pkg.ID).Cols("is_private").Update(pkg); err != nil {
 			return nil, err
 		}
 	}
@@ -218,14 +522,86 @@ func CheckPkg(importPath, rev string) (*Revision, error) {
 		r = &Revision{
 			PkgID:    pkg.ID,
 			Revision: n.Revision,
+			Accessed: time.Now(),
+		}
+	}
+	if len(n.Tag) > 0 && len(r.Tag) == 0 {
+		r.Tag = n.Tag
+	}
+	r.addRef(refKey(n))
+	// Commit metadata is captured when revision is first recorded.
+	if r.ID == 0 {
+		if c, err := n.GetCommit(); err == nil {
+			r.setCommit(c)
+		} else {
+			// Missing metadata does not stop serving.
+			log.Warn("Fail to get commit of %s@%s: %v", n.ImportPath, n.Revision, err)
+			r.Committed = n.Committed
+		}
+
+		if !r.Committed.IsZero() {
+			pv, err := computePseudoVersion(n, r.Committed)
+			if err != nil {
+				log.Warn("Fail to compute pseudo-version of %s@%s: %v", n.ImportPath, n.Revision, err)
+			}
+			r.PseudoVersion = pv
+		}
+	}
+	// Size and checksum are only known when archive is downloaded this time.
+	var b *Blob
+	if len(n.Sha256) > 0 {
+		if b, err = addBlobRef(n.ArchivePath, n.Sha256, n.Size); err != nil {
+			return nil, err
+		}
+		// Reference to previous archive is dropped after new one is added,
+		// so that blob is kept when archive is identical.
+		if r.BlobID > 0 {
+			if err = dropBlobRef(r.BlobID); err != nil {
+				log.Warn("Fail to drop blob reference(%d): %v", r.BlobID, err)
+			}
+		}
+		r.Size = n.Size
+		r.Sha256 = n.Sha256
+		r.BlobID = b.ID
+		r.Storage = b.Storage
+	}
+	if r.ID == 0 {
+		if _, err = x.Insert(r); err != nil {
+			// Another instance may have recorded it at the same time,
+			// which holds its own reference to the blob.
+			if b != nil {
+				dropBlobRef(b.ID)
+			}
+			if r, err = GetRevision(pkg.ID, n.Revision); err != nil {
+				return nil, err
+			}
 		}
-		_, err = x.Insert(r)
-	} else {
-		_, err = x.Id(r.ID).Update(r)
+	} else if _, err = x.Id(r.ID).AllCols().Update(r); err != nil {
+		return nil, err
 	}
+	r.Pkg = pkg
 	return r, nil
 }
 
+// uploadArchive saves archive at given local path to given storage as object of given key.
+func uploadArchive(s Storage, key, localPath string) error {
+	d, err := s.Driver()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return d.Put(key, f, fi.Size())
+}
+
 // IncreasePackageDownloadCount increase package download count by 1.
 func IncreasePackageDownloadCount(importPath string) error {
 	pkg, err := GetPakcageByPath(importPath)
@@ -250,50 +626,21 @@ func SearchPackages(keys string) ([]*Package, error) {
 	}
 
 	pkgs := make([]*Package, 0, 50)
-	err := x.Limit(50).Where("name like '%" + keys + "%'").Find(&pkgs)
+	err := x.Limit(50).Where("name like '%"+keys+"%'").And("is_private=?", false).Find(&pkgs)
 	return pkgs, err
 }
 
-const _EXPIRE_DURATION = -1 * 24 * 30 * 3 * time.Hour
-
-func cleanExpireRevesions() {
-	if err := x.Where("updated<?", time.Now().Add(_EXPIRE_DURATION)).
-		Iterate(new(Revision), func(idx int, bean interface{}) (err error) {
-			rev := bean.(*Revision)
-			if err = rev.GetPackage(); err != nil {
-				return err
-			}
-
-			if _, err = x.Id(rev.ID).Delete(new(Revision)); err != nil {
-				return err
-			}
-
-			ext := archive.GetExtension(rev.Pkg.ImportPath)
-			fpath := path.Join(setting.ArchivePath, rev.Pkg.ImportPath, rev.Revision+ext)
-
-			switch rev.Storage {
-			case LOCAL:
-				os.Remove(fpath)
-				log.Info("Revision deleted (local): %s", fpath)
-				return nil
-			// case QINIU:
-			// 	key, err := rev.KeyName()
-			// 	if err != nil {
-			// 		return err
-			// 	}
-			// 	if setting.ProdMode {
-			// 		if err = qiniu.DeleteArchive(key); err != nil {
-			// 			return err
-			// 		}
-			// 	}
-			// 	log.Info("Revision deleted (qiniu): %s", key)
-			// 	return nil
-			default:
-				return nil
-			}
+// deleteRevision deletes record of given revision and drops its archive.
+func deleteRevision(rev *Revision) error {
+	if err := rev.GetPackage(); err != nil {
+		return err
+	}
+	if _, err := x.Id(rev.ID).Delete(new(Revision)); err != nil {
+		return err
+	}
 
-			return nil
-		}); err != nil {
-		log.Error(3, "Fail to clean expire revisions: %v", err)
+	if err := rev.dropArchive(); err != nil {
+		log.Error(4, "Fail to drop archive(%s@%s): %v", rev.Pkg.ImportPath, rev.Revision, err)
 	}
+	return nil
 }
@@ -0,0 +1,105 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package models
+
+import (
+	"time"
+
+	"github.com/gpmgo/switch/pkg/archive"
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/module"
+	"github.com/gpmgo/switch/pkg/semver"
+)
+
+// _MAX_ANCESTRY_CHECKS is the maximum number of tags to check
+// when looking for the nearest preceding tag of a revision.
+const _MAX_ANCESTRY_CHECKS = 20
+
+// computePseudoVersion returns Go pseudo-version of n.Revision committed at given time,
+// which follows the highest semantic version tag that is an ancestor of the revision.
+// It returns empty string if the revision itself is tagged with a semantic version.
+func computePseudoVersion(n *archive.Node, committed time.Time) (string, error) {
+	refs, err := ListRefs(n)
+	if err != nil {
+		return "", err
+	}
+
+	tags := make([]string, 0, len(refs.Tags))
+	for tag, sha := range refs.Tags {
+		if !module.IsCanonicalTag(tag) {
+			continue
+		} else if sha == n.Revision {
+			return "", nil
+		}
+		tags = append(tags, tag)
+	}
+	semver.Sort(tags)
+
+	for i, tag := range tags {
+		if i == _MAX_ANCESTRY_CHECKS {
+			// Pseudo-version without preceding tag is still valid, only sorts lower.
+			log.Warn("Too many tags to find the nearest preceding one of %s@%s", n.ImportPath, n.Revision)
+			break
+		}
+		ok, err := n.IsAncestor(refs.Tags[tag])
+		if err != nil {
+			return "", err
+		} else if ok {
+			return module.PseudoVersion(tag, committed, n.Revision), nil
+		}
+	}
+	return module.PseudoVersionMajor("", committed, n.Revision), nil
+}
+
+// GetPseudoVersion returns Go pseudo-version of resolved revision of node,
+// which is computed and stored on the revision record if it has not been.
+// It returns empty string if the revision is tagged with a semantic version.
+func GetPseudoVersion(n *archive.Node) (string, error) {
+	var r *Revision
+	pkg, err := GetPakcageByPath(n.ImportPath)
+	if err != nil && err != ErrPackageNotExist {
+		return "", err
+	} else if pkg != nil {
+		if r, err = GetRevision(pkg.ID, n.Revision); err != nil && err != ErrRevisionNotExist {
+			return "", err
+		}
+	}
+	if r != nil && len(r.PseudoVersion) > 0 {
+		return r.PseudoVersion, nil
+	}
+
+	committed := n.Committed
+	if r != nil && !r.Committed.IsZero() {
+		committed = r.Committed
+	}
+	if committed.IsZero() {
+		c, err := n.GetCommit()
+		if err != nil {
+			return "", err
+		}
+		committed = c.Time
+	}
+
+	pv, err := computePseudoVersion(n, committed)
+	if err != nil {
+		return "", err
+	} else if r != nil && len(pv) > 0 {
+		r.PseudoVersion = pv
+		if _, err = x.Id(r.ID).Cols("pseudo_version").Update(r); err != nil {
+			return "", err
+		}
+	}
+	return pv, nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package models
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gpmgo/switch/pkg/archive"
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/semver"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+// RefCache represents a resolved reference of a package.
+type RefCache struct {
+	ID          int64  `xorm:"pk autoincr"`
+	ImportPath  string `xorm:"UNIQUE(s)"` // Requested import path.
+	Ref         string `xorm:"UNIQUE(s)"` // Empty for default branch, "<ref>@<date>" when resolved by date.
+	RootPath    string // Import path of repository root, differs from requested one for vanity import paths.
+	DownloadURL string
+	Revision    string
+	Tag         string // Tag revision is resolved from, or chosen for version query.
+	Immutable   bool
+	Committed   time.Time // Commit time of revision, only known when resolved by date.
+	Resolved    time.Time
+}
+
+// IsExpired returns true if the reference needs to be resolved again.
+func (c *RefCache) IsExpired() bool {
+	return !c.Immutable && time.Since(c.Resolved) > setting.RefCacheTTL
+}
+
+// getRefCache returns cached reference of given import path, it returns nil if not cached.
+func getRefCache(importPath, ref string) (*RefCache, error) {
+	c := new(RefCache)
+	has, err := x.Where("import_path=? AND ref=?", importPath, ref).Get(c)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// apply sets resolved revision to node.
+func (c *RefCache) apply(n *archive.Node) {
+	n.ImportPath = c.RootPath
+	n.DownloadURL = c.DownloadURL
+	n.Revision = c.Revision
+	n.Tag = c.Tag
+	n.Immutable = c.Immutable
+	n.Committed = c.Committed
+	n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+archive.GetExtension(n.ImportPath))
+}
+
+// refKey returns the reference node is requested with,
+// which has resolving date appended if there is one.
+func refKey(n *archive.Node) string {
+	if n.Date.IsZero() {
+		return n.Value
+	}
+	return n.Value + "@" + n.Date.UTC().Format(time.RFC3339)
+}
+
+// ResolveRevision resolves revision of node with cache. Tags, full SHAs and dates
+// in the past never change once resolved, and branches are resolved again after
+// cache TTL. Stale cache is used when upstream fails. Tags moved or deleted upstream
+// keep resolving to the originally cached revisions.
+func ResolveRevision(n *archive.Node) error {
+	importPath, ref := n.ImportPath, refKey(n)
+	c, err := getRefCache(importPath, ref)
+	if err != nil {
+		return err
+	} else if c != nil && !c.IsExpired() {
+		c.apply(n)
+		return nil
+	}
+
+	if err = n.GetRevision(); err != nil {
+		if c != nil {
+			log.Warn("Use stale revision of %s@%s: %v", importPath, ref, err)
+			c.apply(n)
+			return nil
+		} else if !n.Date.IsZero() || semver.IsQuery(n.Value) {
+			return err
+		}
+
+		// Tag deleted upstream is not found anymore.
+		ok, e := pinDriftedTag(n, strings.TrimPrefix(n.Value, "refs/tags/"))
+		if e != nil {
+			return e
+		} else if !ok {
+			return err
+		}
+		n.Immutable = true
+	} else if len(n.Tag) > 0 {
+		if _, err = pinDriftedTag(n, n.Tag); err != nil {
+			return err
+		}
+	}
+
+	isNew := c == nil
+	if isNew {
+		c = &RefCache{
+			ImportPath: importPath,
+			Ref:        ref,
+		}
+	}
+	c.RootPath = n.ImportPath
+	c.DownloadURL = n.DownloadURL
+	c.Revision = n.Revision
+	c.Tag = n.Tag
+	c.Immutable = n.Immutable
+	c.Committed = n.Committed
+	c.Resolved = time.Now()
+	if isNew {
+		_, err = x.Insert(c)
+	} else {
+		_, err = x.Id(c.ID).AllCols().Update(c)
+	}
+	if err != nil {
+		// Failure of caching does not stop serving.
+		log.Error(4, "Fail to cache revision of %s@%s: %v", importPath, ref, err)
+	}
+	return nil
+}
+
+// RefList represents cached references of a package.
+type RefList struct {
+	ID         int64  `xorm:"pk autoincr"`
+	ImportPath string `xorm:"UNIQUE"`
+	Data       string `xorm:"MEDIUMTEXT"` // JSON of references.
+	Resolved   time.Time
+}
+
+// ListRefs returns references of repository of node with cache, which expires
+// after cache TTL and is used when upstream fails. Tags moved or deleted upstream
+// point to the originally cached revisions.
+func ListRefs(n *archive.Node) (*archive.Refs, error) {
+	refs, err := listCachedRefs(n)
+	if err != nil {
+		return nil, err
+	} else if err = pinDriftedTags(n.ImportPath, refs); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// listCachedRefs returns references of repository of node as upstream has them with cache.
+func listCachedRefs(n *archive.Node) (*archive.Refs, error) {
+	l := new(RefList)
+	has, err := x.Where("import_path=?", n.ImportPath).Get(l)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := new(archive.Refs)
+	if has && time.Since(l.Resolved) <= setting.RefCacheTTL {
+		if err = json.Unmarshal([]byte(l.Data), refs); err == nil {
+			return refs, nil
+		}
+		log.Error(4, "Fail to decode cached refs of %s: %v", n.ImportPath, err)
+	}
+
+	refs, err = n.ListRefs()
+	if err != nil {
+		if !has {
+			return nil, err
+		}
+		log.Warn("Use stale refs of %s: %v", n.ImportPath, err)
+		refs = new(archive.Refs)
+		if err = json.Unmarshal([]byte(l.Data), refs); err != nil {
+			return nil, err
+		}
+		return refs, nil
+	}
+
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return nil, err
+	}
+	l.ImportPath = n.ImportPath
+	l.Data = string(data)
+	l.Resolved = time.Now()
+	if has {
+		_, err = x.Id(l.ID).AllCols().Update(l)
+	} else {
+		_, err = x.Insert(l)
+	}
+	if err != nil {
+		// Failure of caching does not stop serving.
+		log.Error(4, "Fail to cache refs of %s: %v", n.ImportPath, err)
+	}
+	return refs, nil
+}
+
+// ListRefCaches returns a list of cached references with given offset.
+func ListRefCaches(offset int) ([]*RefCache, error) {
+	caches := make([]*RefCache, 0, setting.PageSize)
+	return caches, x.Limit(setting.PageSize, offset).Desc("resolved").Find(&caches)
+}
+
+// DeleteRefCache deletes a cached reference by given ID.
+func DeleteRefCache(id int64) error {
+	_, err := x.Id(id).Delete(new(RefCache))
+	return err
+}
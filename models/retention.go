@@ -0,0 +1,209 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+// RetentionRule represents how long revisions of packages
+// with given import path prefix are kept.
+type RetentionRule struct {
+	Prefix   string        // Empty for default rule.
+	MaxAge   time.Duration // Time since last update that revisions expire after, 0 means forever.
+	KeepLast int           // Number of newest revisions of each package that never expire.
+}
+
+// retentionRules are sorted by length of prefix from longest,
+// the last one is the default rule.
+var retentionRules []*RetentionRule
+
+// loadRetentionRules reads rules from "retention.<prefix>" sections of configuration,
+// rules not set in a section fall back to default rule in [cache] section.
+func loadRetentionRules() {
+	def := &RetentionRule{
+		MaxAge:   setting.CacheMaxAge,
+		KeepLast: setting.Cfg.Section("cache").Key("KEEP_LAST").MustInt(0),
+	}
+
+	retentionRules = make([]*RetentionRule, 0, 5)
+	for _, sec := range setting.Cfg.Sections() {
+		if !strings.HasPrefix(sec.Name(), "retention.") {
+			continue
+		}
+		r := &RetentionRule{
+			Prefix:   strings.Trim(strings.TrimPrefix(sec.Name(), "retention."), "/"),
+			MaxAge:   def.MaxAge,
+			KeepLast: sec.Key("KEEP_LAST").MustInt(def.KeepLast),
+		}
+		if sec.HasKey("MAX_AGE") {
+			r.MaxAge = time.Duration(sec.Key("MAX_AGE").MustInt(0)) * 24 * time.Hour
+		}
+		retentionRules = append(retentionRules, r)
+		log.Trace("Retention rule loaded: %s", r.Prefix)
+	}
+	sort.Slice(retentionRules, func(i, j int) bool {
+		return len(retentionRules[i].Prefix) > len(retentionRules[j].Prefix)
+	})
+	retentionRules = append(retentionRules, def)
+}
+
+// MatchRetentionRule returns the rule with longest prefix matches given import path.
+func MatchRetentionRule(importPath string) *RetentionRule {
+	for _, r := range retentionRules {
+		if len(r.Prefix) == 0 || importPath == r.Prefix || strings.HasPrefix(importPath, r.Prefix+"/") {
+			return r
+		}
+	}
+	return retentionRules[len(retentionRules)-1]
+}
+
+// hasMaxAge returns true if revisions of any rule expire.
+func hasMaxAge() bool {
+	for _, r := range retentionRules {
+		if r.MaxAge > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RetentionStatus represents whether and when a revision expires.
+type RetentionStatus struct {
+	Rule    *RetentionRule
+	Pinned  bool
+	Kept    bool      // Among newest revisions of package that never expire.
+	Expires time.Time // Zero if never expires.
+}
+
+// keptRevisions returns IDs of revisions of given package kept by given rule.
+func keptRevisions(pkgID int64, rule *RetentionRule) (map[int64]bool, error) {
+	kept := make(map[int64]bool, rule.KeepLast)
+	if rule.KeepLast == 0 {
+		return kept, nil
+	}
+
+	revs := make([]*Revision, 0, rule.KeepLast)
+	if err := x.Where("pkg_id=?", pkgID).Desc("committed", "updated").
+		Limit(rule.KeepLast).Find(&revs); err != nil {
+		return nil, err
+	}
+	for _, rev := range revs {
+		kept[rev.ID] = true
+	}
+	return kept, nil
+}
+
+// retentionStatus returns retention status of given revision with given
+// matched rule and IDs of kept revisions of its package.
+func retentionStatus(r *Revision, rule *RetentionRule, kept map[int64]bool) *RetentionStatus {
+	s := &RetentionStatus{
+		Rule:   rule,
+		Pinned: r.Pinned,
+		Kept:   kept[r.ID],
+	}
+	if !s.Pinned && !s.Kept && rule.MaxAge > 0 {
+		s.Expires = r.Updated.Add(rule.MaxAge)
+	}
+	return s
+}
+
+// GetRetentionStatus returns retention status of given revision.
+func GetRetentionStatus(r *Revision) (*RetentionStatus, error) {
+	if err := r.GetPackage(); err != nil {
+		return nil, err
+	}
+	rule := MatchRetentionRule(r.Pkg.ImportPath)
+	kept, err := keptRevisions(r.PkgID, rule)
+	if err != nil {
+		return nil, err
+	}
+	return retentionStatus(r, rule, kept), nil
+}
+
+// isRetained returns true if given revision is pinned or kept by retention
+// rule of its package, which cannot be evicted.
+func isRetained(r *Revision) (bool, error) {
+	if r.Pinned {
+		return true, nil
+	}
+	s, err := GetRetentionStatus(r)
+	if err != nil {
+		return false, err
+	}
+	return s.Kept, nil
+}
+
+// SetRevisionPinned sets whether given revision is pinned, pinned
+// revisions never expire or get evicted.
+func SetRevisionPinned(r *Revision, pinned bool) error {
+	r.Pinned = pinned
+	_, err := x.Id(r.ID).Cols("pinned").Update(r)
+	return err
+}
+
+// cleanExpireRevesions deletes revisions expired by retention rules of their packages.
+func cleanExpireRevesions() {
+	if !hasMaxAge() {
+		return
+	}
+
+	pkgs := make([]*Package, 0, 100)
+	if err := x.Find(&pkgs); err != nil {
+		log.Error(4, "Fail to get packages: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, pkg := range pkgs {
+		rule := MatchRetentionRule(pkg.ImportPath)
+		if rule.MaxAge == 0 {
+			continue
+		}
+
+		revs := make([]*Revision, 0, 10)
+		if err := x.Where("pkg_id=? AND pinned=? AND updated<?", pkg.ID, false, now.Add(-rule.MaxAge)).
+			Find(&revs); err != nil {
+			log.Error(4, "Fail to get expired revisions(%s): %v", pkg.ImportPath, err)
+			continue
+		}
+		if len(revs) == 0 {
+			continue
+		}
+		kept, err := keptRevisions(pkg.ID, rule)
+		if err != nil {
+			log.Error(4, "Fail to get kept revisions(%s): %v", pkg.ImportPath, err)
+			continue
+		}
+
+		for _, rev := range revs {
+			if kept[rev.ID] {
+				continue
+			}
+			rev.Pkg = pkg
+			if err = deleteRevision(rev); err != nil {
+				log.Error(4, "Fail to delete revision(%d): %v", rev.ID, err)
+				continue
+			}
+			countExpiration()
+			log.Info("Revision expired (%s): %s@%s", rev.Storage.Name(), pkg.ImportPath, rev.Revision)
+		}
+	}
+}
@@ -15,13 +15,18 @@
 package archive
 
 import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
-	"path"
-	"regexp"
+	"os"
 	"strings"
+	"time"
 
-	"github.com/gpmgo/switch/pkg/setting"
+	"github.com/gpmgo/switch/pkg/semver"
 )
 
 var (
@@ -35,6 +40,16 @@ type Node struct {
 	Value       string
 	Revision    string
 	ArchivePath string
+	Credential  *Credential // Credential to access private repository, nil if public.
+	Immutable   bool        // Revision is resolved from a tag or full SHA.
+	Tag         string      // Tag revision is resolved from, or chosen for version query.
+	Date        time.Time   // Resolve to the last commit at or before the time on branch n.Value if not zero.
+	Committed   time.Time   // Commit time of revision, only known when resolved by date.
+	Commit      *Commit     // Commit metadata of revision, nil if not known yet.
+
+	// Set after download.
+	Size   int64
+	Sha256 string
 }
 
 func joinPath(name string, num int) string {
@@ -47,32 +62,27 @@ func joinPath(name string, num int) string {
 
 // GetRootPath returns project root path.
 func GetRootPath(name string) string {
-	for prefix, num := range setting.RootPathPairs {
-		if strings.HasPrefix(name, prefix) {
-			return joinPath(name, num)
-		}
+	if p := MatchProvider(name); p != nil {
+		return p.RootPath(name)
 	}
 
-	if strings.HasPrefix(name, "gopkg.in") {
-		m := gopkgPathPattern.FindStringSubmatch(strings.TrimPrefix(name, "gopkg.in"))
-		if m == nil {
-			return name
-		}
-		user := m[1]
-		repo := m[2]
-		if len(user) == 0 {
-			user = "go-" + repo
-		}
-		return path.Join("gopkg.in", user, repo+"."+m[3])
+	// Vanity import paths are only known after dynamic discovery.
+	if r := getCachedDynamic(name); r != nil && r.err == nil {
+		return r.RootPath
 	}
 	return name
 }
 
 // GetExtension returns extension by import path.
 func GetExtension(importPath string) string {
-	for prefix, ext := range setting.ExtensionPairs {
-		if strings.HasPrefix(importPath, prefix) {
-			return ext
+	if p := MatchProvider(importPath); p != nil {
+		return p.Extension()
+	}
+
+	// Archives of vanity import paths are from service their repositories are on.
+	if r := getCachedDynamic(importPath); r != nil && r.err == nil {
+		if p := MatchProvider(r.RepoPath); p != nil {
+			return p.Extension()
 		}
 	}
 	return ".zip"
@@ -87,80 +97,196 @@ func NewNode(importPath string, val string) *Node {
 	}
 }
 
-type (
-	// service represents a source code control service.
-	service struct {
-		pattern *regexp.Regexp
-		prefix  string
-		get     func(*http.Client, map[string]string, *Node) error
+var defaultTags = map[string]string{"git": "master", "hg": "default", "svn": "trunk"}
+
+// ListRefs returns references of repository of node.
+func (n *Node) ListRefs() (*Refs, error) {
+	if n.Credential != nil && n.Credential.Type == CREDENTIAL_SSH {
+		return n.listSSHRefs()
+	} else if p := MatchProvider(n.ImportPath); p != nil {
+		return p.ListRefs(n.client(), n.ImportPath)
 	}
-	revService struct {
-		prefix string
-		get    func(*http.Client, *Node) error
+	return n.listDynamicRefs(n.client())
+}
+
+// GetRevision fetches revision of node from service. A version query in n.Value,
+// e.g. "^1.4" or "latest-stable", is resolved by the highest matching version tag.
+func (n *Node) GetRevision() (err error) {
+	if !n.Date.IsZero() {
+		return n.getDateRevision()
+	} else if semver.IsQuery(n.Value) {
+		return n.getQueryRevision()
 	}
-)
 
-var (
-	// services is the list of source code control services handled by gopm.
-	services = []*service{
-		{githubPattern, "github.com/", getGithubArchive},
-		{googlePattern, "code.google.com/", getGoogleArchive},
-		{golangPattern, "golang.org/x/", getGolangArchive},
-		{bitbucketPattern, "bitbucket.org/", getBitbucketArchive},
-		// {oscPattern, "git.oschina.net/", getOscPkg},
-		// {gitcafePattern, "gitcafe.com/", getGitcafePkg},
-		// {launchpadPattern, "launchpad.net/", getLaunchpadPkg},
-		{gopkgPattern, "gopkg.in/", getGopkgArchive},
-	}
-	revServices = []*revService{
-		{"github.com/", getGithubRevision},
-		{"code.google.com/", getGolangRevision},
-		{"golang.org/x/", getGolangRevision},
-		{"bitbucket.org/", getBitbucketRevision},
-		{"gopkg.in/", getGopkgRevision},
-	}
-	defaultTags = map[string]string{"git": "master", "hg": "default", "svn": "trunk"}
-)
+	if n.Credential != nil && n.Credential.Type == CREDENTIAL_SSH {
+		err = n.getSSHRevision()
+	} else if p := MatchProvider(n.ImportPath); p != nil {
+		err = p.GetRevision(n.client(), n)
+	} else {
+		err = n.getDynamicRevision(n.client())
+	}
+	if err == nil && IsSHA(n.Value) {
+		n.Immutable = true
+	}
+	return err
+}
 
-// GetRevision fetches revision of node from service.
-func (n *Node) GetRevision() error {
-	for _, s := range revServices {
-		if !strings.HasPrefix(n.ImportPath, s.prefix) {
-			continue
-		}
-		return s.get(HttpClient, n)
+// getQueryRevision resolves revision of node by the highest version tag matches n.Value.
+func (n *Node) getQueryRevision() error {
+	refs, err := n.ListRefs()
+	if err != nil {
+		return err
 	}
-	return ErrNotMatchAnyService
+	tags := make([]string, 0, len(refs.Tags))
+	for name := range refs.Tags {
+		tags = append(tags, name)
+	}
+	tag, err := semver.Select(tags, n.Value)
+	if err != nil {
+		return fmt.Errorf("fail to select version(%s): %v", n.ImportPath, err)
+	}
+
+	query := n.Value
+	n.Value = tag
+	err = n.GetRevision()
+	n.Value = query
+	if err != nil {
+		return err
+	}
+
+	// Query may resolve to another tag when new one is released.
+	n.Tag = tag
+	n.Immutable = false
+	return nil
+}
+
+// historyProvider returns provider and repository root import path to look up
+// commit history of node, provider is nil if there is none.
+func (n *Node) historyProvider(client *http.Client) (Provider, string) {
+	// Commit history cannot be looked up without cloning through SSH.
+	if n.Credential != nil && n.Credential.Type == CREDENTIAL_SSH {
+		return nil, ""
+	} else if p := MatchProvider(n.ImportPath); p != nil {
+		return p, n.ImportPath
+	}
+
+	r, err := getDynamic(client, n.ImportPath)
+	if err != nil {
+		return nil, ""
+	}
+	p := MatchProvider(r.RepoPath)
+	if p == nil {
+		return nil, ""
+	}
+	return p, p.RootPath(r.RepoPath)
+}
+
+// GetCommit returns commit metadata of n.Revision.
+func (n *Node) GetCommit() (*Commit, error) {
+	if n.Commit != nil {
+		return n.Commit, nil
+	}
+
+	client := n.client()
+	p, importPath := n.historyProvider(client)
+	g, ok := p.(CommitGetter)
+	if !ok {
+		return nil, ErrCommitNotSupported
+	}
+	c, err := g.GetCommit(client, importPath, n.Revision)
+	if err != nil {
+		return nil, err
+	}
+	n.Commit = c
+	return c, nil
+}
+
+// IsAncestor returns true if given commit is an ancestor of or same as n.Revision.
+func (n *Node) IsAncestor(ancestor string) (bool, error) {
+	if ancestor == n.Revision {
+		return true, nil
+	}
+
+	client := n.client()
+	p, importPath := n.historyProvider(client)
+	c, ok := p.(AncestryChecker)
+	if !ok {
+		return false, ErrAncestryNotSupported
+	}
+	return c.IsAncestor(client, importPath, ancestor, n.Revision)
+}
+
+// getDateRevision resolves revision of node by the last commit
+// at or before n.Date on branch n.Value through commit history.
+func (n *Node) getDateRevision() error {
+	client := n.client()
+	p, importPath := n.historyProvider(client)
+	f, ok := p.(CommitFinder)
+	if !ok {
+		return ErrDateNotSupported
+	}
+	c, err := f.FindCommit(client, importPath, n.Value, n.Date)
+	if err != nil {
+		return err
+	}
+
+	branch, date := n.Value, n.Date
+	n.Value, n.Date = c.SHA, time.Time{}
+	err = n.GetRevision()
+	n.Value, n.Date = branch, date
+	if err != nil {
+		return err
+	}
+
+	n.Committed = c.Time
+	n.Commit = c
+	// Branch can still get new commits before a future time.
+	n.Immutable = date.Before(time.Now())
+	return nil
 }
 
 // Download downloads remote package without version control.
 func (n *Node) Download() error {
-	for _, s := range services {
-		if !strings.HasPrefix(n.DownloadURL, s.prefix) {
-			continue
-		}
-
-		m := s.pattern.FindStringSubmatch(n.DownloadURL)
-		if m == nil {
-			if s.prefix != "" {
-				return errors.New("Cannot match package service prefix by given path")
-			}
-			continue
-		}
+	if n.Credential != nil && n.Credential.Type == CREDENTIAL_SSH {
+		return n.downloadSSH()
+	}
 
-		match := map[string]string{"downloadURL": n.DownloadURL}
-		for i, n := range s.pattern.SubexpNames() {
-			if n != "" {
-				match[n] = m[i]
-			}
-		}
-		return s.get(HttpClient, match, n)
+	if p := MatchProvider(n.DownloadURL); p != nil {
+		return p.Download(n.client(), n)
 	}
 
 	if n.ImportPath != n.DownloadURL {
 		return errors.New("Didn't find any match service")
 	}
 	return ErrNotMatchAnyService
-	// log.Log("Cannot match any service, getting dynamic...")
-	// return n.getDynamic(HttpClient, ctx)
+}
+
+// saveArchive verifies the downloaded archive at given temporary path,
+// records its size and SHA-256 checksum, and moves it to n.ArchivePath.
+func (n *Node) saveArchive(tmpPath string) error {
+	// Opening reads the central directory, which is at the end of file
+	// and thus catches truncated archives.
+	zr, err := zip.OpenReader(tmpPath)
+	if err != nil {
+		return fmt.Errorf("invalid archive: %v", err)
+	}
+	zr.Close()
+
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmpPath, n.ArchivePath); err != nil {
+		return err
+	}
+	n.Size = size
+	n.Sha256 = hex.EncodeToString(h.Sum(nil))
+	return nil
 }
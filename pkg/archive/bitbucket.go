@@ -17,51 +17,183 @@ package archive
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"path"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/Unknwon/com"
+	"gopkg.in/ini.v1"
 
 	"github.com/gpmgo/switch/pkg/setting"
 )
 
 var (
-	bitbucketRevisionPattern = regexp.MustCompile(`data-revision="[a-z0-9A-Z]+`)
-	bitbucketPattern         = regexp.MustCompile(`^bitbucket\.org/(?P<owner>[a-z0-9A-Z_.\-]+)/(?P<repo>[a-z0-9A-Z_.\-]+)(?P<dir>/[a-z0-9A-Z_.\-/]*)?$`)
-	bitbucketEtagRe          = regexp.MustCompile(`^(hg|git)-`)
+	bitbucketEtagRe = regexp.MustCompile(`^(hg|git)-`)
 )
 
-func getBitbucketRevision(client *http.Client, n *Node) error {
-	if len(n.Value) == 0 {
+// _BITBUCKET_MAX_COMMIT_PAGES is the maximum pages of commits to look through,
+// because commits API of Bitbucket cannot be filtered by time.
+const _BITBUCKET_MAX_COMMIT_PAGES = 20
+
+// bitbucketProvider represents Bitbucket or a Bitbucket-compatible instance.
+type bitbucketProvider struct {
+	baseProvider
+	webURL     string
+	apiURL     string
+	archiveURL string
+}
+
+func newBitbucketProvider(name string, sec *ini.Section) (Provider, error) {
+	p := &bitbucketProvider{
+		baseProvider: newBaseProvider(name, sec, "bitbucket.org/", 3),
+		webURL:       strings.TrimSuffix(sec.Key("WEB_URL").MustString("https://bitbucket.org"), "/"),
+		apiURL:       strings.TrimSuffix(sec.Key("API_URL").MustString("https://api.bitbucket.org/2.0"), "/"),
+	}
+	p.archiveURL = strings.TrimSuffix(sec.Key("ARCHIVE_URL").MustString(p.webURL), "/")
+	return p, nil
+}
+
+// match returns owner and repository name of given import path.
+func (p *bitbucketProvider) match(importPath string) (map[string]string, error) {
+	return matchPattern(ownerRepoPattern, strings.TrimPrefix(importPath, p.prefix))
+}
+
+func (p *bitbucketProvider) GetRevision(client *http.Client, n *Node) error {
+	match, err := p.match(n.ImportPath)
+	if err != nil {
+		return err
+	}
+	match["webURL"] = p.webURL
+
+	if err = getGitRevision(client, n, com.Expand("{webURL}/{owner}/{repo}.git", match)); err != nil {
+		return err
+	}
+	n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+p.ext)
+	return nil
+}
+
+func (p *bitbucketProvider) ListRefs(client *http.Client, importPath string) (*Refs, error) {
+	match, err := p.match(importPath)
+	if err != nil {
+		return nil, err
+	}
+	match["webURL"] = p.webURL
+	return getGitRefs(client, com.Expand("{webURL}/{owner}/{repo}.git", match), nil)
+}
+
+// bitbucketCommit represents a commit returned by commits API of Bitbucket.
+type bitbucketCommit struct {
+	Hash    string    `json:"hash"`
+	Date    time.Time `json:"date"`
+	Message string    `json:"message"`
+	Author  struct {
+		Raw  string `json:"raw"` // In form of "Name <email>".
+		User struct {
+			DisplayName string `json:"display_name"`
+		} `json:"user"`
+	} `json:"author"`
+}
+
+func (c *bitbucketCommit) toCommit() *Commit {
+	author := c.Author.User.DisplayName
+	if len(author) == 0 {
+		author = strings.TrimSpace(strings.Split(c.Author.Raw, "<")[0])
+	}
+	return &Commit{
+		SHA:     c.Hash,
+		Time:    c.Date,
+		Author:  author,
+		Subject: commitSubject(c.Message),
+	}
+}
+
+func (p *bitbucketProvider) FindCommit(client *http.Client, importPath, branch string, t time.Time) (*Commit, error) {
+	match, err := p.match(importPath)
+	if err != nil {
+		return nil, err
+	}
+	match["apiURL"] = p.apiURL
+
+	if len(branch) == 0 {
 		var repo struct {
-			Scm string
+			MainBranch struct {
+				Name string `json:"name"`
+			} `json:"mainbranch"`
+		}
+		if err = com.HttpGetJSON(client, com.Expand("{apiURL}/repositories/{owner}/{repo}", match), &repo); err != nil {
+			return nil, fmt.Errorf("fail to get repository(%s): %w", importPath, err)
 		}
-		if err := com.HttpGetJSON(client, fmt.Sprintf("https://api.bitbucket.org/1.0/repositories/%s", strings.TrimPrefix(n.ImportPath, "bitbucket.org/")), &repo); err != nil {
-			return fmt.Errorf("fail to fetch page: %v", err)
+		branch = repo.MainBranch.Name
+	}
+	match["branch"] = url.QueryEscape(branch)
+
+	// Commits are listed from the newest, follow pages until one is old enough.
+	next := com.Expand("{apiURL}/repositories/{owner}/{repo}/commits/{branch}?pagelen=100", match)
+	for i := 0; i < _BITBUCKET_MAX_COMMIT_PAGES && len(next) > 0; i++ {
+		var page struct {
+			Values []*bitbucketCommit `json:"values"`
+			Next   string             `json:"next"`
+		}
+		if err = com.HttpGetJSON(client, next, &page); err != nil {
+			return nil, fmt.Errorf("fail to get commits(%s): %w", importPath, err)
 		}
-		n.Value = defaultTags[repo.Scm]
+		for _, c := range page.Values {
+			if !c.Date.After(t) {
+				return c.toCommit(), nil
+			}
+		}
+		next = page.Next
 	}
-	data, err := com.HttpGetBytes(client, fmt.Sprintf("https://%s/commits/%s", n.ImportPath, n.Value), nil)
+	return nil, fmt.Errorf("cannot find commit at or before %s: %s", t.Format(time.RFC3339), importPath)
+}
+
+func (p *bitbucketProvider) GetCommit(client *http.Client, importPath, sha string) (*Commit, error) {
+	match, err := p.match(importPath)
 	if err != nil {
-		return fmt.Errorf("fail to get revision(%s): %v", n.ImportPath, err)
+		return nil, err
 	}
-	m := bitbucketRevisionPattern.FindSubmatch(data)
-	if m == nil {
-		return fmt.Errorf("cannot find revision in page: %s", n.ImportPath)
+	match["apiURL"] = p.apiURL
+	match["sha"] = sha
+
+	commit := new(bitbucketCommit)
+	if err = com.HttpGetJSON(client, com.Expand("{apiURL}/repositories/{owner}/{repo}/commit/{sha}", match), commit); err != nil {
+		return nil, fmt.Errorf("fail to get commit(%s): %w", importPath, err)
 	}
-	n.Revision = strings.TrimPrefix(string(m[0]), `data-revision="`)
-	n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+".zip")
-	return nil
+	return commit.toCommit(), nil
 }
 
-func getBitbucketArchive(client *http.Client, match map[string]string, n *Node) error {
+func (p *bitbucketProvider) IsAncestor(client *http.Client, importPath, ancestor, sha string) (bool, error) {
+	match, err := p.match(importPath)
+	if err != nil {
+		return false, err
+	}
+	match["apiURL"] = p.apiURL
+	match["ancestor"] = ancestor
+	match["sha"] = sha
+
+	var base struct {
+		Hash string `json:"hash"`
+	}
+	if err = com.HttpGetJSON(client, com.Expand("{apiURL}/repositories/{owner}/{repo}/merge-base/{ancestor}..{sha}", match), &base); err != nil {
+		return false, fmt.Errorf("fail to get merge base(%s): %w", importPath, err)
+	}
+	return base.Hash == ancestor, nil
+}
+
+func (p *bitbucketProvider) Download(client *http.Client, n *Node) error {
+	match, err := p.match(n.DownloadURL)
+	if err != nil {
+		return err
+	}
+	match["archiveURL"] = p.archiveURL
 	match["sha"] = n.Revision
 
 	// Downlaod archive.
-	if err := com.HttpGetToFile(client,
-		com.Expand("https://bitbucket.org/{owner}/{repo}/get/{sha}.zip", match), nil, n.ArchivePath); err != nil {
-		return fmt.Errorf("fail to download archive(%s): %v", n.ImportPath, err)
+	if err := httpGetArchive(client,
+		com.Expand("{archiveURL}/{owner}/{repo}/get/{sha}.zip", match), nil, n); err != nil {
+		return fmt.Errorf("fail to download archive(%s): %w", n.ImportPath, err)
 	}
 	return nil
 }
@@ -0,0 +1,93 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ttlCache is a cache of values that expire after their own TTLs. It is bounded
+// by number of entries, least recently used ones are evicted when it is full.
+type ttlCache struct {
+	lock    sync.Mutex
+	max     int
+	entries *list.List // Most recently used at front.
+	items   map[string]*list.Element
+}
+
+type ttlEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func newTTLCache(max int) *ttlCache {
+	return &ttlCache{
+		max:     max,
+		entries: list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+func (c *ttlCache) remove(e *list.Element) {
+	c.entries.Remove(e)
+	delete(c.items, e.Value.(*ttlEntry).key)
+}
+
+// get returns value of given key if it is cached and not expired.
+func (c *ttlCache) get(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*ttlEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.entries.MoveToFront(e)
+	return entry.value, true
+}
+
+// set caches value of given key for given duration.
+func (c *ttlCache) set(key string, value interface{}, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		entry := e.Value.(*ttlEntry)
+		entry.value = value
+		entry.expires = time.Now().Add(ttl)
+		c.entries.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.entries.PushFront(&ttlEntry{key, value, time.Now().Add(ttl)})
+	for c.entries.Len() > c.max {
+		c.remove(c.entries.Back())
+	}
+}
+
+// len returns number of entries, including expired ones not evicted yet.
+func (c *ttlCache) len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.entries.Len()
+}
@@ -0,0 +1,112 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	c := newTTLCache(2)
+	c.set("a", 1, time.Hour)
+	c.set("b", 2, time.Hour)
+	if v, ok := c.get("a"); !ok || v.(int) != 1 {
+		t.Fatalf("get(a) = %v, %v", v, ok)
+	}
+
+	// "b" is least recently used now.
+	c.set("c", 3, time.Hour)
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used entry is not evicted")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("recently used entry is evicted")
+	}
+	if c.len() != 2 {
+		t.Errorf("len = %d, want 2", c.len())
+	}
+
+	c.set("a", 4, -time.Second)
+	if _, ok := c.get("a"); ok {
+		t.Error("expired entry is returned")
+	}
+	if c.len() != 1 {
+		t.Errorf("len = %d, want 1", c.len())
+	}
+}
+
+func TestGetCachedDynamic(t *testing.T) {
+	defer func(c *ttlCache) { dynamicCache = c }(dynamicCache)
+	dynamicCache = newTTLCache(_DYNAMIC_MAX_RESULTS)
+
+	root := &dynamicResult{RootPath: "example.org/repo"}
+	dynamicCache.set(root.RootPath, root, time.Hour)
+	dynamicCache.set("example.org/repo/missing", &dynamicResult{err: ErrNotMatchAnyService}, time.Hour)
+
+	if r := getCachedDynamic("example.org/repo/sub/pkg"); r != root {
+		t.Errorf("result of root is not used for subpackage: %v", r)
+	}
+	if r := getCachedDynamic("example.org/repo/missing"); r == nil || r.err == nil {
+		t.Errorf("negative result is not used for exact path: %v", r)
+	}
+	if r := getCachedDynamic("example.org/repo/missing/pkg"); r != root {
+		t.Errorf("negative result is used for subpackage: %v", r)
+	}
+}
+
+func TestGetDynamicNegative(t *testing.T) {
+	defer func(c *ttlCache) { dynamicCache = c }(dynamicCache)
+	dynamicCache = newTTLCache(_DYNAMIC_MAX_RESULTS)
+
+	requests := make(map[string]int)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		if r.URL.Path == "/down" {
+			w.WriteHeader(502)
+			return
+		}
+		w.Write([]byte(`<html><head><meta name="go-import" content="example.org/other git https://github.com/user/other"></head></html>`))
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	// Failures to fetch page are returned as is and not cached.
+	for i := 0; i < 2; i++ {
+		if _, err := getDynamic(srv.Client(), host+"/down"); err == nil || !strings.Contains(err.Error(), "status code 502") {
+			t.Errorf("err = %v, want failure of fetching page", err)
+		}
+	}
+	if requests["/down"] != 2 {
+		t.Errorf("requests of unavailable page = %d, want 2", requests["/down"])
+	}
+
+	// Page without matching go-import meta tag is cached.
+	for i := 0; i < 2; i++ {
+		_, err := getDynamic(srv.Client(), host+"/missing")
+		if err == nil {
+			t.Fatal("discovery succeeds without matching meta tag")
+		}
+		if err = dynamicError(err); err != ErrNotMatchAnyService {
+			t.Errorf("err = %v, want %v", err, ErrNotMatchAnyService)
+		}
+	}
+	if requests["/missing"] != 1 {
+		t.Errorf("requests of page without meta tag = %d, want 1", requests["/missing"])
+	}
+}
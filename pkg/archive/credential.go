@@ -0,0 +1,108 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"net/http"
+	"strings"
+)
+
+type CredentialType string
+
+const (
+	CREDENTIAL_TOKEN CredentialType = "token"
+	CREDENTIAL_BASIC CredentialType = "basic"
+	CREDENTIAL_SSH   CredentialType = "ssh"
+)
+
+// Credential represents a credential to access private repositories on a host.
+type Credential struct {
+	Type     CredentialType
+	Host     string
+	Username string
+	Secret   string // Token, password or SSH private key.
+}
+
+// matchHost returns true if given host is the credential host or its subdomain.
+func (c *Credential) matchHost(host string) bool {
+	if i := strings.Index(host, ":"); i > -1 {
+		host = host[:i]
+	}
+	return host == c.Host || strings.HasSuffix(host, "."+c.Host)
+}
+
+// apply sets credential to request if request is sent to the credential host.
+func (c *Credential) apply(req *http.Request) {
+	if !c.matchHost(req.URL.Host) {
+		return
+	}
+
+	switch c.Type {
+	case CREDENTIAL_TOKEN:
+		// Git smart HTTP only accepts token as password of basic authentication.
+		if strings.HasSuffix(req.URL.Path, "/info/refs") {
+			username := c.Username
+			if len(username) == 0 {
+				username = "oauth2"
+			}
+			req.SetBasicAuth(username, c.Secret)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+c.Secret)
+		}
+	case CREDENTIAL_BASIC:
+		req.SetBasicAuth(c.Username, c.Secret)
+	}
+}
+
+// credentialTransport applies credential to requests before sending by underlying transport.
+type credentialTransport struct {
+	cred *Credential
+	t    http.RoundTripper
+}
+
+func (t *credentialTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	t.cred.apply(r)
+	return t.t.RoundTrip(r)
+}
+
+// CredentialOf returns credential applies to given import path, or nil if there is none.
+// It is set by the package manages credentials.
+var CredentialOf = func(importPath string) (*Credential, error) {
+	return nil, nil
+}
+
+// credentialClient returns HTTP client that carries given credential if any.
+func credentialClient(cred *Credential) *http.Client {
+	if cred == nil {
+		return HttpClient
+	}
+	return &http.Client{
+		Transport: &credentialTransport{
+			cred: cred,
+			t:    httpTransport,
+		},
+	}
+}
+
+// client returns HTTP client to fetch node, which carries credential of node if any.
+func (n *Node) client() *http.Client {
+	return credentialClient(n.Credential)
+}
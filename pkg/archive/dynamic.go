@@ -0,0 +1,280 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+const (
+	_DYNAMIC_CACHE_DURATION    = 24 * time.Hour
+	_DYNAMIC_NEGATIVE_DURATION = 10 * time.Minute
+	_DYNAMIC_MAX_RESULTS       = 10000
+)
+
+// metaImport represents the parsed <meta name="go-import"
+// content="prefix vcs reporoot" /> tags from HTML files.
+type metaImport struct {
+	Prefix, VCS, RepoRoot string
+}
+
+// metaSource represents the parsed <meta name="go-source"
+// content="prefix home directory file" /> tags from HTML files.
+type metaSource struct {
+	Prefix, Home, Directory, File string
+}
+
+// errNoGoImport is returned when page of import path has no go-import meta tag
+// matches it, which is the only failure of discovery to be cached.
+var errNoGoImport = errors.New("cannot find go-import meta tag")
+
+// dynamicResult represents result of a dynamic discovery.
+type dynamicResult struct {
+	RootPath string // Import path of repository root.
+	RepoURL  string
+	RepoPath string // Repository URL without scheme and ".git" suffix.
+	Home     string // Home page from go-source, if any.
+	err      error
+}
+
+// dynamicCache maps import paths to results, least recently used ones are
+// evicted when it is full.
+var dynamicCache = newTTLCache(_DYNAMIC_MAX_RESULTS)
+
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// parseMetaGoImports returns meta imports and sources from the HTML in r.
+// Parsing ends at the end of the <head> section or the beginning of the <body>.
+func parseMetaGoImports(r io.Reader) (imports []metaImport, sources []metaSource, err error) {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "ascii", "utf-8", "utf8":
+			return input, nil
+		}
+		return nil, fmt.Errorf("can't decode XML document using charset %q", charset)
+	}
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	var t xml.Token
+	for {
+		t, err = d.RawToken()
+		if err != nil {
+			if err == io.EOF || len(imports) > 0 {
+				err = nil
+			}
+			return
+		}
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			return
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			return
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") {
+			continue
+		}
+
+		fields := strings.Fields(attrValue(e.Attr, "content"))
+		switch attrValue(e.Attr, "name") {
+		case "go-import":
+			if len(fields) == 3 {
+				imports = append(imports, metaImport{
+					Prefix:   fields[0],
+					VCS:      fields[1],
+					RepoRoot: fields[2],
+				})
+			}
+		case "go-source":
+			if len(fields) >= 2 {
+				s := metaSource{Prefix: fields[0], Home: fields[1]}
+				if len(fields) >= 4 {
+					s.Directory, s.File = fields[2], fields[3]
+				}
+				sources = append(sources, s)
+			}
+		}
+	}
+}
+
+// matchGoImport returns the meta import whose prefix matches given import path.
+func matchGoImport(imports []metaImport, importPath string) (*metaImport, error) {
+	var match *metaImport
+	for i, im := range imports {
+		if importPath != im.Prefix && !strings.HasPrefix(importPath, im.Prefix+"/") {
+			continue
+		}
+		if match != nil {
+			return nil, fmt.Errorf("multiple meta tags match import path %q", importPath)
+		}
+		match = &imports[i]
+	}
+	if match == nil {
+		return nil, fmt.Errorf("%w for import path %q", errNoGoImport, importPath)
+	}
+	return match, nil
+}
+
+// discover fetches go-import meta tags of given import path and
+// computes the repository root and URL.
+func discover(client *http.Client, importPath string) (*dynamicResult, error) {
+	reqURL := "https://" + importPath + "?go-get=1"
+	log.Trace("Request URL: %s", reqURL)
+
+	resp, err := client.Get(reqURL)
+	if err != nil {
+		return nil, fmt.Errorf("fail to fetch page: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("fail to fetch page(%s): status code %d", reqURL, resp.StatusCode)
+	}
+
+	imports, sources, err := parseMetaGoImports(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse meta tags: %v", err)
+	}
+	im, err := matchGoImport(imports, importPath)
+	if err != nil {
+		return nil, err
+	}
+	if im.VCS != "git" {
+		return nil, fmt.Errorf("unsupported VCS %q: %s", im.VCS, importPath)
+	}
+
+	r := &dynamicResult{
+		RootPath: im.Prefix,
+		RepoURL:  im.RepoRoot,
+	}
+	r.RepoPath = strings.TrimSuffix(im.RepoRoot, ".git")
+	if i := strings.Index(r.RepoPath, "://"); i > -1 {
+		r.RepoPath = r.RepoPath[i+3:]
+	}
+	for _, s := range sources {
+		if s.Prefix == im.Prefix {
+			r.Home = s.Home
+			break
+		}
+	}
+	return r, nil
+}
+
+// getCachedDynamic returns cached discovery result of given import path.
+func getCachedDynamic(importPath string) *dynamicResult {
+	for name := importPath; name != "." && name != "/"; name = path.Dir(name) {
+		v, ok := dynamicCache.get(name)
+		if !ok {
+			continue
+		}
+		// Negative results only apply to exact import path.
+		r := v.(*dynamicResult)
+		if r.err != nil && name != importPath {
+			continue
+		}
+		return r
+	}
+	return nil
+}
+
+// getDynamic discovers repository of given import path, results are cached
+// including paths without go-import meta tag to not hammer the remote for
+// unresolvable paths. Failures to fetch the page are not cached.
+func getDynamic(client *http.Client, importPath string) (*dynamicResult, error) {
+	if r := getCachedDynamic(importPath); r != nil {
+		return r, r.err
+	}
+
+	r, err := discover(client, importPath)
+	if err != nil {
+		log.Debug("Dynamic discovery failed(%s): %v", importPath, err)
+		if errors.Is(err, errNoGoImport) {
+			dynamicCache.set(importPath, &dynamicResult{err: err}, _DYNAMIC_NEGATIVE_DURATION)
+		}
+		return nil, err
+	}
+	dynamicCache.set(r.RootPath, r, _DYNAMIC_CACHE_DURATION)
+	return r, nil
+}
+
+// dynamicError returns error to report for failed discovery of vanity import path.
+func dynamicError(err error) error {
+	if errors.Is(err, errNoGoImport) {
+		return ErrNotMatchAnyService
+	}
+	return err
+}
+
+// listDynamicRefs returns references of repository of node with vanity import path.
+func (n *Node) listDynamicRefs(client *http.Client) (*Refs, error) {
+	r, err := getDynamic(client, n.ImportPath)
+	if err != nil {
+		return nil, dynamicError(err)
+	}
+
+	p := MatchProvider(r.RepoPath)
+	if p == nil {
+		return nil, ErrNotMatchAnyService
+	}
+	return p.ListRefs(client, p.RootPath(r.RepoPath))
+}
+
+// getDynamicRevision resolves revision of node with vanity import path
+// by the service of repository its go-import meta tag points to.
+func (n *Node) getDynamicRevision(client *http.Client) error {
+	r, err := getDynamic(client, n.ImportPath)
+	if err != nil {
+		return dynamicError(err)
+	}
+
+	p := MatchProvider(r.RepoPath)
+	if p == nil {
+		return ErrNotMatchAnyService
+	}
+
+	cn := *n
+	cn.ImportPath = p.RootPath(r.RepoPath)
+	cn.DownloadURL = cn.ImportPath
+	if err = p.GetRevision(client, &cn); err != nil {
+		return err
+	}
+
+	n.ImportPath = r.RootPath
+	n.DownloadURL = cn.DownloadURL
+	n.Revision = cn.Revision
+	n.Immutable = cn.Immutable
+	n.Tag = cn.Tag
+	n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+p.Extension())
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Unknwon/com"
+	"gopkg.in/ini.v1"
+
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+// giteaProvider represents a self-hosted Gitea or Gogs instance.
+type giteaProvider struct {
+	baseProvider
+	baseURL string
+	token   string
+}
+
+func newGiteaProvider(name string, sec *ini.Section) (Provider, error) {
+	p := &giteaProvider{
+		baseProvider: newBaseProvider(name, sec, "gitea.com/", 3),
+		baseURL:      strings.TrimSuffix(sec.Key("BASE_URL").MustString("https://gitea.com"), "/"),
+		token:        sec.Key("TOKEN").String(),
+	}
+	if !strings.HasSuffix(p.prefix, "/") {
+		return nil, fmt.Errorf("PREFIX must end with '/': %s", p.prefix)
+	}
+	return p, nil
+}
+
+func (p *giteaProvider) header() http.Header {
+	header := make(http.Header)
+	if len(p.token) > 0 {
+		header.Set("Authorization", "token "+p.token)
+	}
+	return header
+}
+
+// match returns owner and repository name of given import path.
+func (p *giteaProvider) match(importPath string) (map[string]string, error) {
+	match, err := matchPattern(ownerRepoPattern, strings.TrimPrefix(importPath, p.prefix))
+	if err != nil {
+		return nil, err
+	}
+	match["baseURL"] = p.baseURL
+	return match, nil
+}
+
+func (p *giteaProvider) GetRevision(client *http.Client, n *Node) error {
+	match, err := p.match(n.ImportPath)
+	if err != nil {
+		return err
+	}
+
+	// References tell whether revision is resolved from a tag,
+	// API is only asked for what they do not have (e.g. abbreviated SHAs).
+	if ok, err := resolveByRefs(n, func() (*Refs, error) { return p.ListRefs(client, n.ImportPath) }); err != nil {
+		log.Warn("Fail to resolve revision(%s) by refs: %v", n.ImportPath, err)
+	} else if ok {
+		n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+p.ext)
+		return nil
+	}
+
+	if len(n.Value) == 0 {
+		var repo struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		if err = httpGetJSON(client, com.Expand("{baseURL}/api/v1/repos/{owner}/{repo}", match), p.header(), &repo); err != nil {
+			return fmt.Errorf("fail to get repository(%s): %w", n.ImportPath, err)
+		}
+		n.Value = repo.DefaultBranch
+	}
+
+	match["ref"] = url.QueryEscape(n.Value)
+	var commits []struct {
+		SHA string `json:"sha"`
+	}
+	if err = httpGetJSON(client, com.Expand("{baseURL}/api/v1/repos/{owner}/{repo}/commits?sha={ref}&limit=1", match), p.header(), &commits); err == nil && len(commits) > 0 {
+		n.Revision = commits[0].SHA
+	} else {
+		// Gogs does not support listing commits, only branches can be resolved.
+		var branch struct {
+			Commit struct {
+				ID string `json:"id"`
+			} `json:"commit"`
+		}
+		if err = httpGetJSON(client, com.Expand("{baseURL}/api/v1/repos/{owner}/{repo}/branches/{ref}", match), p.header(), &branch); err != nil {
+			return fmt.Errorf("fail to get revision(%s): %w", n.ImportPath, err)
+		}
+		n.Revision = branch.Commit.ID
+	}
+	n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+p.ext)
+	return nil
+}
+
+func (p *giteaProvider) FindCommit(client *http.Client, importPath, branch string, t time.Time) (*Commit, error) {
+	match, err := p.match(importPath)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	if len(branch) > 0 {
+		query.Set("sha", branch)
+	}
+	query.Set("until", t.UTC().Format(time.RFC3339))
+	query.Set("limit", "1")
+	match["query"] = query.Encode()
+
+	var commits []*githubCommit
+	if err = httpGetJSON(client, com.Expand("{baseURL}/api/v1/repos/{owner}/{repo}/commits?{query}", match), p.header(), &commits); err != nil {
+		return nil, fmt.Errorf("fail to get commits(%s): %w", importPath, err)
+	} else if len(commits) == 0 {
+		return nil, fmt.Errorf("cannot find commit at or before %s: %s", t.Format(time.RFC3339), importPath)
+	}
+	// Older versions of Gitea ignore the "until" parameter.
+	c := commits[0].toCommit()
+	return c, checkCommitTime(c, t)
+}
+
+func (p *giteaProvider) GetCommit(client *http.Client, importPath, sha string) (*Commit, error) {
+	match, err := p.match(importPath)
+	if err != nil {
+		return nil, err
+	}
+	match["sha"] = sha
+
+	commit := new(githubCommit)
+	if err = httpGetJSON(client, com.Expand("{baseURL}/api/v1/repos/{owner}/{repo}/git/commits/{sha}", match), p.header(), commit); err != nil {
+		return nil, fmt.Errorf("fail to get commit(%s): %w", importPath, err)
+	}
+	return commit.toCommit(), nil
+}
+
+func (p *giteaProvider) ListRefs(client *http.Client, importPath string) (*Refs, error) {
+	match, err := p.match(importPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Git smart HTTP only accepts token as password of basic authentication.
+	header := make(http.Header)
+	if len(p.token) > 0 {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(match["owner"]+":"+p.token)))
+	}
+	return getGitRefs(client, com.Expand("{baseURL}/{owner}/{repo}.git", match), header)
+}
+
+func (p *giteaProvider) Download(client *http.Client, n *Node) error {
+	match, err := p.match(n.DownloadURL)
+	if err != nil {
+		return err
+	}
+	match["sha"] = n.Revision
+
+	if err = httpGetArchive(client,
+		com.Expand("{baseURL}/{owner}/{repo}/archive/{sha}.zip", match), p.header(), n); err != nil {
+		return fmt.Errorf("fail to download archive(%s): %w", n.ImportPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testGiteaServer returns a server of Gitea API, or Gogs API which cannot list commits.
+// Neither serves references, so revisions are resolved through API.
+func testGiteaServer(t *testing.T, gogs bool, data []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token api-token" {
+			http.Error(w, "401 Unauthorized", 401)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/api/v1/repos/owner/repo":
+			w.Write([]byte(`{"default_branch": "master"}`))
+		case "/api/v1/repos/owner/repo/commits":
+			if gogs {
+				http.NotFound(w, r)
+				return
+			}
+			switch r.URL.Query().Get("sha") {
+			case "master":
+				w.Write([]byte(`[{"sha": "` + shaHead + `"}]`))
+			case "ab12cd3":
+				w.Write([]byte(`[{"sha": "` + shaTagPeel + `"}]`))
+			default:
+				w.Write([]byte(`[]`))
+			}
+		case "/api/v1/repos/owner/repo/branches/master":
+			w.Write([]byte(`{"commit": {"id": "` + shaHead + `"}}`))
+		case "/owner/repo/archive/" + testSHA + ".zip":
+			w.Write(data)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestGiteaGetRevision(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		gogs  bool
+		value string
+		sha   string // Empty if it cannot be resolved.
+	}{
+		{"gitea default branch", false, "", shaHead},
+		{"gitea branch", false, "master", shaHead},
+		{"gitea abbreviated SHA", false, "ab12cd3", shaTagPeel},
+		{"gogs default branch", true, "", shaHead},
+		{"gogs branch", true, "master", shaHead},
+		{"gogs abbreviated SHA", true, "ab12cd3", ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			srv := testGiteaServer(t, c.gogs, nil)
+			defer srv.Close()
+
+			sec := testProviderSection(t, srv.URL, "git.example.com/")
+			sec.NewKey("TOKEN", "api-token")
+			p, err := newGiteaProvider("gitea", sec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			n := NewNode("git.example.com/owner/repo", c.value)
+			err = p.GetRevision(HttpClient, n)
+			if len(c.sha) == 0 {
+				if err == nil {
+					t.Fatalf("GetRevision(%q) = %s, want error", c.value, n.Revision)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetRevision(%q): %v", c.value, err)
+			} else if n.Revision != c.sha {
+				t.Errorf("GetRevision(%q) = %s, want %s", c.value, n.Revision, c.sha)
+			}
+		})
+	}
+}
+
+func TestGiteaDownload(t *testing.T) {
+	data := testZip(t, "repo")
+	srv := testGiteaServer(t, false, data)
+	defer srv.Close()
+
+	sec := testProviderSection(t, srv.URL, "git.example.com/")
+	sec.NewKey("TOKEN", "api-token")
+	p, err := newGiteaProvider("gitea", sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, clean := testNode(t, "git.example.com/owner/repo")
+	defer clean()
+	n.DownloadURL = n.ImportPath
+	if err = p.Download(HttpClient, n); err != nil {
+		t.Fatalf("Download: %v", err)
+	} else if n.Size != int64(len(data)) {
+		t.Errorf("size = %d, want %d", n.Size, len(data))
+	}
+}
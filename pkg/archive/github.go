@@ -15,78 +15,242 @@
 package archive
 
 import (
-	"bytes"
 	"fmt"
 	"net/http"
+	"net/url"
 	"path"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/Unknwon/com"
+	"gopkg.in/ini.v1"
 
 	"github.com/gpmgo/switch/pkg/setting"
 )
 
-var (
-	githubRevisionPattern = regexp.MustCompile(`value="[a-z0-9A-Z]+"`)
-	githubPattern         = regexp.MustCompile(`^github\.com/(?P<owner>[a-z0-9A-Z_.\-]+)/(?P<repo>[a-z0-9A-Z_.\-]+)(?P<dir>/[a-z0-9A-Z_.\-/]*)?$`)
-	golangPattern         = regexp.MustCompile(`^golang\.org/x/(?P<repo>[a-z0-9\-]+)?(?P<dir>/[a-z0-9A-Z_.\-/]+)?$`)
-)
+var githubShortSHAPattern = regexp.MustCompile(`^[0-9a-f]{7,39}$`)
+
+// githubProvider represents GitHub or a GitHub Enterprise instance.
+type githubProvider struct {
+	baseProvider
+	webURL     string
+	apiURL     string
+	archiveURL string
+}
 
-func getGithubRevision(client *http.Client, n *Node) error {
-	if len(n.Value) == 0 {
-		n.Value = "master"
+func newGithubBase(name string, sec *ini.Section, prefix string) githubProvider {
+	p := githubProvider{
+		baseProvider: newBaseProvider(name, sec, prefix, 3),
+		webURL:       strings.TrimSuffix(sec.Key("WEB_URL").MustString("https://github.com"), "/"),
+		apiURL:       strings.TrimSuffix(sec.Key("API_URL").MustString("https://api.github.com"), "/"),
 	}
-	data, err := com.HttpGetBytes(client, fmt.Sprintf("https://%s/commits/%s", n.ImportPath, n.Value), nil)
+	p.archiveURL = strings.TrimSuffix(sec.Key("ARCHIVE_URL").MustString(p.webURL), "/")
+	return p
+}
+
+func newGithubProvider(name string, sec *ini.Section) (Provider, error) {
+	p := newGithubBase(name, sec, "github.com/")
+	return &p, nil
+}
+
+// repoPath returns "{owner}/{repo}" of given import path.
+func (p *githubProvider) repoPath(importPath string) (string, error) {
+	match, err := matchPattern(ownerRepoPattern, strings.TrimPrefix(importPath, p.prefix))
 	if err != nil {
-		return fmt.Errorf("fail to get revision(%s): %v", n.ImportPath, err)
+		return "", err
 	}
+	return match["owner"] + "/" + match["repo"], nil
+}
 
-	i := bytes.Index(data, []byte(`commit-links-group BtnGroup`))
-	if i == -1 {
-		return fmt.Errorf("cannot find locater in page: %s", n.ImportPath)
-	}
-	data = data[i:]
-	m := githubRevisionPattern.FindSubmatch(data)
-	if m == nil {
-		return fmt.Errorf("cannot find revision in page: %s", n.ImportPath)
+// getRevision resolves revision of node in given repository.
+func (p *githubProvider) getRevision(client *http.Client, n *Node, repoPath string) error {
+	err := getGitRevision(client, n, p.webURL+"/"+repoPath+".git")
+	if err != nil {
+		// Abbreviated SHAs cannot be found in references, ask API instead.
+		if !githubShortSHAPattern.MatchString(n.Value) {
+			return err
+		}
+		var commit struct {
+			Sha string `json:"sha"`
+		}
+		if err = com.HttpGetJSON(client, fmt.Sprintf("%s/repos/%s/commits/%s", p.apiURL, repoPath, n.Value), &commit); err != nil {
+			return fmt.Errorf("fail to get revision(%s): %w", n.ImportPath, err)
+		}
+		n.Revision = commit.Sha
+		n.Immutable = true
 	}
-	n.Revision = strings.TrimSuffix(strings.TrimPrefix(string(m[0]), `value="`), `"`)
-	n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+".zip")
+	n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+p.ext)
 	return nil
 }
 
-func getGithubArchive(client *http.Client, match map[string]string, n *Node) error {
-	match["sha"] = n.Revision
-	// match["cred"] = setting.GithubCredentials
+// githubCommit represents a commit returned by commits API of GitHub and Gitea.
+type githubCommit struct {
+	Sha    string `json:"sha"`
+	Commit struct {
+		Author struct {
+			Name string `json:"name"`
+		} `json:"author"`
+		Committer struct {
+			Date time.Time `json:"date"`
+		} `json:"committer"`
+		Message string `json:"message"`
+	} `json:"commit"`
+}
+
+func (c *githubCommit) toCommit() *Commit {
+	return &Commit{
+		SHA:     c.Sha,
+		Time:    c.Commit.Committer.Date,
+		Author:  c.Commit.Author.Name,
+		Subject: commitSubject(c.Commit.Message),
+	}
+}
+
+// findCommit returns the last commit on given branch of given repository at or before given time.
+func (p *githubProvider) findCommit(client *http.Client, repoPath, branch string, t time.Time) (*Commit, error) {
+	query := url.Values{}
+	if len(branch) > 0 {
+		query.Set("sha", branch)
+	}
+	query.Set("until", t.UTC().Format(time.RFC3339))
+	query.Set("per_page", "1")
 
+	var commits []*githubCommit
+	if err := com.HttpGetJSON(client, fmt.Sprintf("%s/repos/%s/commits?%s", p.apiURL, repoPath, query.Encode()), &commits); err != nil {
+		return nil, fmt.Errorf("fail to get commits(%s): %w", repoPath, err)
+	} else if len(commits) == 0 {
+		return nil, fmt.Errorf("cannot find commit at or before %s: %s", t.Format(time.RFC3339), repoPath)
+	}
+	return commits[0].toCommit(), nil
+}
+
+// getCommit returns commit of given SHA in given repository.
+func (p *githubProvider) getCommit(client *http.Client, repoPath, sha string) (*Commit, error) {
+	commit := new(githubCommit)
+	if err := com.HttpGetJSON(client, fmt.Sprintf("%s/repos/%s/commits/%s", p.apiURL, repoPath, sha), commit); err != nil {
+		return nil, fmt.Errorf("fail to get commit(%s): %w", repoPath, err)
+	}
+	return commit.toCommit(), nil
+}
+
+// isAncestor returns true if commit ancestor is an ancestor of or same as commit sha in given repository.
+func (p *githubProvider) isAncestor(client *http.Client, repoPath, ancestor, sha string) (bool, error) {
+	var compare struct {
+		Status string `json:"status"`
+	}
+	if err := com.HttpGetJSON(client, fmt.Sprintf("%s/repos/%s/compare/%s...%s", p.apiURL, repoPath, ancestor, sha), &compare); err != nil {
+		return false, fmt.Errorf("fail to compare commits(%s): %w", repoPath, err)
+	}
+	return compare.Status == "ahead" || compare.Status == "identical", nil
+}
+
+// download fetches archive of node from given repository.
+func (p *githubProvider) download(client *http.Client, n *Node, repoPath string) error {
 	// We use .zip here.
-	// zip: https://github.com/{owner}/{repo}/archive/{sha}.zip
-	// tarball: https://github.com/{owner}/{repo}/tarball/{sha}
+	// zip: {archiveURL}/{owner}/{repo}/archive/{sha}.zip
+	// tarball: {archiveURL}/{owner}/{repo}/tarball/{sha}
+	archiveURL := fmt.Sprintf("%s/%s/archive/%s.zip", p.archiveURL, repoPath, n.Revision)
+
+	// Web archive URL does not accept tokens, archives of private repositories
+	// are requested through API, which redirects to a temporary URL.
+	// zipball: {apiURL}/repos/{owner}/{repo}/zipball/{sha}
+	if n.Credential != nil {
+		archiveURL = fmt.Sprintf("%s/repos/%s/zipball/%s", p.apiURL, repoPath, n.Revision)
+	}
 
 	// Downlaod archive.
-	if err := com.HttpGetToFile(client,
-		com.Expand("https://github.com/{owner}/{repo}/archive/{sha}.zip", match), nil, n.ArchivePath); err != nil {
-		return fmt.Errorf("fail to download archive(%s): %v", n.ImportPath, err)
+	if err := httpGetArchive(client, archiveURL, nil, n); err != nil {
+		return fmt.Errorf("fail to download archive(%s): %w", n.ImportPath, err)
 	}
 	return nil
 }
 
-func getGolangRevision(client *http.Client, n *Node) error {
-	var cn Node
-	cn = *n
-	cn.ImportPath = "github.com/golang" + strings.TrimPrefix(cn.ImportPath, "golang.org/x")
+func (p *githubProvider) GetRevision(client *http.Client, n *Node) error {
+	repoPath, err := p.repoPath(n.ImportPath)
+	if err != nil {
+		return err
+	}
+	return p.getRevision(client, n, repoPath)
+}
 
-	if err := getGithubRevision(client, &cn); err != nil {
+func (p *githubProvider) ListRefs(client *http.Client, importPath string) (*Refs, error) {
+	repoPath, err := p.repoPath(importPath)
+	if err != nil {
+		return nil, err
+	}
+	return getGitRefs(client, p.webURL+"/"+repoPath+".git", nil)
+}
+
+func (p *githubProvider) FindCommit(client *http.Client, importPath, branch string, t time.Time) (*Commit, error) {
+	repoPath, err := p.repoPath(importPath)
+	if err != nil {
+		return nil, err
+	}
+	return p.findCommit(client, repoPath, branch, t)
+}
+
+func (p *githubProvider) GetCommit(client *http.Client, importPath, sha string) (*Commit, error) {
+	repoPath, err := p.repoPath(importPath)
+	if err != nil {
+		return nil, err
+	}
+	return p.getCommit(client, repoPath, sha)
+}
+
+func (p *githubProvider) IsAncestor(client *http.Client, importPath, ancestor, sha string) (bool, error) {
+	repoPath, err := p.repoPath(importPath)
+	if err != nil {
+		return false, err
+	}
+	return p.isAncestor(client, repoPath, ancestor, sha)
+}
+
+func (p *githubProvider) Download(client *http.Client, n *Node) error {
+	repoPath, err := p.repoPath(n.DownloadURL)
+	if err != nil {
 		return err
 	}
+	return p.download(client, n, repoPath)
+}
 
-	n.Revision = cn.Revision
-	n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+".zip")
-	return nil
+// golangProvider represents golang.org/x/* repositories mirrored on GitHub.
+type golangProvider struct {
+	githubProvider
+	owner string
+}
+
+func newGolangProvider(name string, sec *ini.Section) (Provider, error) {
+	return &golangProvider{
+		githubProvider: newGithubBase(name, sec, "golang.org/x/"),
+		owner:          sec.Key("OWNER").MustString("golang"),
+	}, nil
+}
+
+func (p *golangProvider) repoPath(importPath string) string {
+	return p.owner + "/" + strings.Split(strings.TrimPrefix(importPath, p.prefix), "/")[0]
+}
+
+func (p *golangProvider) GetRevision(client *http.Client, n *Node) error {
+	return p.getRevision(client, n, p.repoPath(n.ImportPath))
+}
+
+func (p *golangProvider) ListRefs(client *http.Client, importPath string) (*Refs, error) {
+	return getGitRefs(client, p.webURL+"/"+p.repoPath(importPath)+".git", nil)
+}
+
+func (p *golangProvider) FindCommit(client *http.Client, importPath, branch string, t time.Time) (*Commit, error) {
+	return p.findCommit(client, p.repoPath(importPath), branch, t)
+}
+
+func (p *golangProvider) GetCommit(client *http.Client, importPath, sha string) (*Commit, error) {
+	return p.getCommit(client, p.repoPath(importPath), sha)
+}
+
+func (p *golangProvider) IsAncestor(client *http.Client, importPath, ancestor, sha string) (bool, error) {
+	return p.isAncestor(client, p.repoPath(importPath), ancestor, sha)
 }
 
-func getGolangArchive(client *http.Client, match map[string]string, n *Node) error {
-	match["owner"] = "golang"
-	return getGithubArchive(client, match, n)
+func (p *golangProvider) Download(client *http.Client, n *Node) error {
+	return p.download(client, n, p.repoPath(n.DownloadURL))
 }
@@ -0,0 +1,122 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSHA = "0123456789abcdef0123456789abcdef01234567"
+
+// testZip returns a zip archive with a single file under given top-level directory.
+func testZip(t *testing.T, dir string) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	w, err := zw.Create(dir + "/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("package main\n"))
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testNode returns a node of given import path to be saved in a temporary directory.
+func testNode(t *testing.T, importPath string) (*Node, func()) {
+	dir, err := ioutil.TempDir("", "switch-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewNode(importPath, testSHA)
+	n.Revision = testSHA
+	n.ArchivePath = filepath.Join(dir, testSHA+".zip")
+	return n, func() { os.RemoveAll(dir) }
+}
+
+func TestGithubDownload(t *testing.T) {
+	data := testZip(t, "repo-"+testSHA)
+
+	var paths, auths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		auths = append(auths, r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/api/repos/owner/repo/zipball/" + testSHA:
+			http.Redirect(w, r, "/codeload/owner/repo/legacy.zip/"+testSHA, http.StatusFound)
+		case "/owner/repo/archive/" + testSHA + ".zip", "/codeload/owner/repo/legacy.zip/" + testSHA:
+			w.Write(data)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p := &githubProvider{
+		apiURL:     srv.URL + "/api",
+		archiveURL: srv.URL,
+	}
+
+	t.Run("public", func(t *testing.T) {
+		paths, auths = nil, nil
+		n, clean := testNode(t, "github.com/owner/repo")
+		defer clean()
+
+		if err := p.download(n.client(), n, "owner/repo"); err != nil {
+			t.Fatalf("download: %v", err)
+		}
+		if len(paths) != 1 || paths[0] != "/owner/repo/archive/"+testSHA+".zip" {
+			t.Errorf("requested paths = %v, want web archive URL", paths)
+		}
+		if auths[0] != "" {
+			t.Errorf("Authorization = %q, want none", auths[0])
+		}
+		if n.Size != int64(len(data)) {
+			t.Errorf("size = %d, want %d", n.Size, len(data))
+		}
+	})
+
+	t.Run("private", func(t *testing.T) {
+		paths, auths = nil, nil
+		n, clean := testNode(t, "github.com/owner/repo")
+		defer clean()
+		n.Credential = &Credential{
+			Type:   CREDENTIAL_TOKEN,
+			Host:   "127.0.0.1",
+			Secret: "secret-token",
+		}
+
+		if err := p.download(n.client(), n, "owner/repo"); err != nil {
+			t.Fatalf("download: %v", err)
+		}
+		if len(paths) != 2 || paths[0] != "/api/repos/owner/repo/zipball/"+testSHA {
+			t.Fatalf("requested paths = %v, want zipball API URL first", paths)
+		}
+		if auths[0] != "Bearer secret-token" {
+			t.Errorf("Authorization = %q, want %q", auths[0], "Bearer secret-token")
+		}
+		if _, err := os.Stat(n.ArchivePath); err != nil {
+			t.Errorf("archive is not saved: %v", err)
+		}
+	})
+}
@@ -0,0 +1,230 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Unknwon/com"
+	"gopkg.in/ini.v1"
+
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+const (
+	_GITLAB_ROOT_DURATION     = 24 * time.Hour
+	_GITLAB_NOT_ROOT_DURATION = 10 * time.Minute
+	_GITLAB_MAX_ROOTS         = 10000
+	// _GITLAB_MAX_DEPTH is the maximum number of path segments of a project,
+	// which is nested in at most 20 levels of groups.
+	_GITLAB_MAX_DEPTH = 21
+)
+
+// gitlabProvider represents a GitLab instance, either gitlab.com or self-hosted.
+type gitlabProvider struct {
+	baseProvider
+	baseURL string
+	token   string
+
+	roots *ttlCache // Project path -> root import path, empty if not a project.
+}
+
+func newGitlabProvider(name string, sec *ini.Section) (Provider, error) {
+	p := &gitlabProvider{
+		baseProvider: newBaseProvider(name, sec, "gitlab.com/", 3),
+		baseURL:      strings.TrimSuffix(sec.Key("BASE_URL").MustString("https://gitlab.com"), "/"),
+		token:        sec.Key("TOKEN").String(),
+		roots:        newTTLCache(_GITLAB_MAX_ROOTS),
+	}
+	if !strings.HasSuffix(p.prefix, "/") {
+		return nil, fmt.Errorf("PREFIX must end with '/': %s", p.prefix)
+	}
+	return p, nil
+}
+
+func (p *gitlabProvider) header() http.Header {
+	header := make(http.Header)
+	if len(p.token) > 0 {
+		header.Set("PRIVATE-TOKEN", p.token)
+	}
+	return header
+}
+
+// projectURL returns API URL of project with given path.
+func (p *gitlabProvider) projectURL(projectPath string) string {
+	return p.baseURL + "/api/v4/projects/" + url.QueryEscape(projectPath)
+}
+
+// RootPath returns import path of project root. Projects can be nested in
+// groups at any depth up to a limit, and cannot contain other projects or groups,
+// so the longest path that is a project is looked up through API, and falls back
+// to the configured depth when lookup fails. Private projects are only visible
+// with credential of the import path, and lookups failed for reasons other than
+// 404 (e.g. authentication or rate limits) are not cached.
+func (p *gitlabProvider) RootPath(importPath string) string {
+	fields := strings.Split(strings.TrimPrefix(importPath, p.prefix), "/")
+	if len(fields) > _GITLAB_MAX_DEPTH {
+		fields = fields[:_GITLAB_MAX_DEPTH]
+	}
+
+	// Any known root saves lookups of longer paths.
+	for i := 2; i <= len(fields); i++ {
+		if root, ok := p.roots.get(strings.Join(fields[:i], "/")); ok && len(root.(string)) > 0 {
+			return root.(string)
+		}
+	}
+
+	var client *http.Client
+	for i := len(fields); i >= 2; i-- {
+		projectPath := strings.Join(fields[:i], "/")
+		if _, ok := p.roots.get(projectPath); ok {
+			continue
+		}
+
+		if client == nil {
+			cred, err := CredentialOf(importPath)
+			if err != nil {
+				log.Error(4, "Fail to get credential(%s): %v", importPath, err)
+				break
+			}
+			client = credentialClient(cred)
+		}
+
+		_, err := com.HttpGetBytes(client, p.projectURL(projectPath), p.header())
+		if err != nil && !isNotFound(err) {
+			log.Error(4, "Fail to look up GitLab project(%s): %v", projectPath, err)
+			break
+		} else if err != nil {
+			p.roots.set(projectPath, "", _GITLAB_NOT_ROOT_DURATION)
+			continue
+		}
+
+		root := p.prefix + projectPath
+		p.roots.set(projectPath, root, _GITLAB_ROOT_DURATION)
+		return root
+	}
+	return p.baseProvider.RootPath(importPath)
+}
+
+func (p *gitlabProvider) GetRevision(client *http.Client, n *Node) error {
+	projectURL := p.projectURL(strings.TrimPrefix(n.ImportPath, p.prefix))
+
+	// References tell whether revision is resolved from a tag,
+	// API is only asked for what they do not have (e.g. abbreviated SHAs).
+	if ok, err := resolveByRefs(n, func() (*Refs, error) { return p.ListRefs(client, n.ImportPath) }); err != nil {
+		log.Warn("Fail to resolve revision(%s) by refs: %v", n.ImportPath, err)
+	} else if ok {
+		n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+p.ext)
+		return nil
+	}
+
+	if len(n.Value) == 0 {
+		var project struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		if err := httpGetJSON(client, projectURL, p.header(), &project); err != nil {
+			return fmt.Errorf("fail to get project(%s): %w", n.ImportPath, err)
+		}
+		n.Value = project.DefaultBranch
+	}
+
+	var commit struct {
+		ID string `json:"id"`
+	}
+	if err := httpGetJSON(client, projectURL+"/repository/commits/"+url.QueryEscape(n.Value), p.header(), &commit); err != nil {
+		return fmt.Errorf("fail to get revision(%s): %w", n.ImportPath, err)
+	}
+	n.Revision = commit.ID
+	n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+p.ext)
+	return nil
+}
+
+// gitlabCommit represents a commit returned by commits API of GitLab.
+type gitlabCommit struct {
+	ID            string    `json:"id"`
+	Title         string    `json:"title"`
+	AuthorName    string    `json:"author_name"`
+	CommittedDate time.Time `json:"committed_date"`
+}
+
+func (c *gitlabCommit) toCommit() *Commit {
+	return &Commit{
+		SHA:     c.ID,
+		Time:    c.CommittedDate,
+		Author:  c.AuthorName,
+		Subject: c.Title,
+	}
+}
+
+func (p *gitlabProvider) FindCommit(client *http.Client, importPath, branch string, t time.Time) (*Commit, error) {
+	query := url.Values{}
+	if len(branch) > 0 {
+		query.Set("ref_name", branch)
+	}
+	query.Set("until", t.UTC().Format(time.RFC3339))
+	query.Set("per_page", "1")
+
+	var commits []*gitlabCommit
+	if err := httpGetJSON(client, p.projectURL(strings.TrimPrefix(importPath, p.prefix))+"/repository/commits?"+query.Encode(), p.header(), &commits); err != nil {
+		return nil, fmt.Errorf("fail to get commits(%s): %w", importPath, err)
+	} else if len(commits) == 0 {
+		return nil, fmt.Errorf("cannot find commit at or before %s: %s", t.Format(time.RFC3339), importPath)
+	}
+	c := commits[0].toCommit()
+	return c, checkCommitTime(c, t)
+}
+
+func (p *gitlabProvider) GetCommit(client *http.Client, importPath, sha string) (*Commit, error) {
+	commit := new(gitlabCommit)
+	if err := httpGetJSON(client, p.projectURL(strings.TrimPrefix(importPath, p.prefix))+"/repository/commits/"+sha, p.header(), commit); err != nil {
+		return nil, fmt.Errorf("fail to get commit(%s): %w", importPath, err)
+	}
+	return commit.toCommit(), nil
+}
+
+func (p *gitlabProvider) IsAncestor(client *http.Client, importPath, ancestor, sha string) (bool, error) {
+	var base struct {
+		ID string `json:"id"`
+	}
+	query := url.Values{"refs[]": []string{ancestor, sha}}
+	if err := httpGetJSON(client, p.projectURL(strings.TrimPrefix(importPath, p.prefix))+"/repository/merge_base?"+query.Encode(), p.header(), &base); err != nil {
+		return false, fmt.Errorf("fail to get merge base(%s): %w", importPath, err)
+	}
+	return base.ID == ancestor, nil
+}
+
+func (p *gitlabProvider) ListRefs(client *http.Client, importPath string) (*Refs, error) {
+	// Git smart HTTP only accepts token as password of basic authentication.
+	header := make(http.Header)
+	if len(p.token) > 0 {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("oauth2:"+p.token)))
+	}
+	return getGitRefs(client, p.baseURL+"/"+strings.TrimPrefix(importPath, p.prefix)+".git", header)
+}
+
+func (p *gitlabProvider) Download(client *http.Client, n *Node) error {
+	archiveURL := p.projectURL(strings.TrimPrefix(n.DownloadURL, p.prefix)) + "/repository/archive.zip?sha=" + n.Revision
+	if err := httpGetArchive(client, archiveURL, p.header(), n); err != nil {
+		return fmt.Errorf("fail to download archive(%s): %w", n.ImportPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/ini.v1"
+)
+
+// testProviderSection returns configuration section of provider with given base URL.
+func testProviderSection(t *testing.T, baseURL, prefix string) *ini.Section {
+	sec, err := ini.Empty().NewSection("provider.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec.NewKey("BASE_URL", baseURL)
+	sec.NewKey("PREFIX", prefix)
+	return sec
+}
+
+func TestGitlabGetRevision(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/group/sub/repo.git/info/refs":
+			w.Write(recordedRefs)
+		case "/api/v4/projects/group/sub/repo/repository/commits/ab12cd3":
+			w.Write([]byte(`{"id": "` + shaTagPeel + `"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p, err := newGitlabProvider("gitlab", testProviderSection(t, srv.URL, "git.example.com/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		value     string
+		revision  string
+		tag       string
+		immutable bool
+	}{
+		{"v1.0.0", shaTagPeel, "v1.0.0", true},
+		{"refs/tags/v1.1.0", shaLight, "v1.1.0", true},
+		{"develop", shaDevelop, "", false},
+		{"", shaHead, "", false},
+		{"ab12cd3", shaTagPeel, "", false},
+	}
+	for _, c := range cases {
+		n := NewNode("git.example.com/group/sub/repo", c.value)
+		if err = p.GetRevision(HttpClient, n); err != nil {
+			t.Errorf("GetRevision(%q): %v", c.value, err)
+			continue
+		}
+		if n.Revision != c.revision || n.Tag != c.tag || n.Immutable != c.immutable {
+			t.Errorf("GetRevision(%q) = (%s, tag %q, immutable %v), want (%s, tag %q, immutable %v)",
+				c.value, n.Revision, n.Tag, n.Immutable, c.revision, c.tag, c.immutable)
+		}
+	}
+}
+
+func TestGitlabRootPath(t *testing.T) {
+	status := map[string]int{
+		"/api/v4/projects/group%2Fsub%2Frepo": 200,
+	}
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.EscapedPath()]++
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			http.Error(w, "401 Unauthorized", 401)
+			return
+		}
+		if code, ok := status[r.URL.EscapedPath()]; ok {
+			w.WriteHeader(code)
+			w.Write([]byte("{}"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	defer func(f func(string) (*Credential, error)) { CredentialOf = f }(CredentialOf)
+	CredentialOf = func(importPath string) (*Credential, error) {
+		return &Credential{Type: CREDENTIAL_TOKEN, Host: "127.0.0.1", Secret: "secret-token"}, nil
+	}
+
+	p, err := newGitlabProvider("gitlab", testProviderSection(t, srv.URL, "git.example.com/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, importPath := range []string{
+		"git.example.com/group/sub/repo/pkg",
+		"git.example.com/group/sub/repo/pkg",
+		"git.example.com/group/sub/repo/other",
+	} {
+		if root := p.RootPath(importPath); root != "git.example.com/group/sub/repo" {
+			t.Errorf("RootPath(%q) = %q, want nested project", importPath, root)
+		}
+	}
+	// Longest path is looked up first, and shorter ones are not needed.
+	if len(requests) != 2 || requests["/api/v4/projects/group%2Fsub%2Frepo%2Fpkg"] != 1 ||
+		requests["/api/v4/projects/group%2Fsub%2Frepo"] != 1 {
+		t.Errorf("unexpected lookups: %v", requests)
+	}
+
+	// Lookups of deep paths are bounded by maximum depth of projects.
+	requests = make(map[string]int)
+	p.RootPath("git.example.com/" + strings.Repeat("a/", 50) + "pkg")
+	if len(requests) != _GITLAB_MAX_DEPTH-1 {
+		t.Errorf("%d lookups for deep path, want %d", len(requests), _GITLAB_MAX_DEPTH-1)
+	}
+
+	// Authentication failure falls back to configured depth and is not cached.
+	CredentialOf = func(importPath string) (*Credential, error) { return nil, nil }
+	for i := 0; i < 2; i++ {
+		if root := p.RootPath("git.example.com/team/repo/pkg"); root != "git.example.com/team/repo" {
+			t.Errorf("RootPath = %q, want configured depth", root)
+		}
+	}
+	if requests["/api/v4/projects/team%2Frepo%2Fpkg"] != 2 {
+		t.Errorf("failed lookup is cached: %v", requests)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"strings"
 
 	"github.com/Unknwon/com"
+	"gopkg.in/ini.v1"
 
 	"github.com/gpmgo/switch/pkg/setting"
 )
@@ -32,6 +33,40 @@ var (
 	googlePattern         = regexp.MustCompile(`^code\.google\.com/p/(?P<repo>[a-z0-9\-]+)(:?\.(?P<subrepo>[a-z0-9\-]+))?(?P<dir>/[a-z0-9A-Z_.\-/]+)?$`)
 )
 
+type googleProvider struct {
+	baseProvider
+}
+
+func newGoogleProvider(name string, sec *ini.Section) (Provider, error) {
+	return &googleProvider{newBaseProvider(name, sec, "code.google.com/", 3)}, nil
+}
+
+func (p *googleProvider) GetRevision(client *http.Client, n *Node) error {
+	return getGoogleRevision(client, n)
+}
+
+func (p *googleProvider) ListRefs(client *http.Client, importPath string) (*Refs, error) {
+	match, err := matchPattern(googlePattern, importPath)
+	if err != nil {
+		return nil, err
+	}
+	setupGoogleMatch(match)
+	if err = getGoogleVCS(client, match); err != nil {
+		return nil, err
+	} else if match["vcs"] != "git" {
+		return nil, fmt.Errorf("listing references of %s is not supported", match["vcs"])
+	}
+	return getGitRefs(client, com.Expand("https://code.google.com/p/{repo}{dot}{subrepo}", match), nil)
+}
+
+func (p *googleProvider) Download(client *http.Client, n *Node) error {
+	match, err := matchPattern(googlePattern, n.DownloadURL)
+	if err != nil {
+		return err
+	}
+	return getGoogleArchive(client, match, n)
+}
+
 func setupGoogleMatch(match map[string]string) {
 	if s := match["subrepo"]; s != "" {
 		match["dot"] = "."
@@ -46,7 +81,7 @@ func getGoogleVCS(client *http.Client, match map[string]string) error {
 	// Scrape the HTML project page to find the VCS.
 	p, err := com.HttpGetBytes(client, com.Expand("http://code.google.com/p/{repo}/source/checkout", match), nil)
 	if err != nil {
-		return fmt.Errorf("fail to fetch page: %v", err)
+		return fmt.Errorf("fail to fetch page: %w", err)
 	}
 	m := googleRepoRe.FindSubmatch(p)
 	if m == nil {
@@ -68,29 +103,29 @@ func getGoogleRevision(client *http.Client, n *Node) error {
 		setupGoogleMatch(match)
 	}
 
-	if len(n.Value) == 0 {
-		// Scrape the HTML project page to find the VCS.
-		p, err := com.HttpGetBytes(client, com.Expand("http://code.google.com/p/{repo}/source/checkout", match), nil)
+	if err := getGoogleVCS(client, match); err != nil {
+		return err
+	}
+
+	if match["vcs"] == "git" {
+		if err := getGitRevision(client, n, com.Expand("https://code.google.com/p/{repo}{dot}{subrepo}", match)); err != nil {
+			return err
+		}
+	} else {
+		if len(n.Value) == 0 {
+			n.Value = defaultTags[match["vcs"]]
+		}
+		match["tag"] = n.Value
+		data, err := com.HttpGetBytes(client, com.Expand("http://code.google.com/p/{repo}/source/browse/?repo={subrepo}&r={tag}", match), nil)
 		if err != nil {
-			return fmt.Errorf("fail to fetch page: %v", err)
+			return fmt.Errorf("fail to get revision(%s): %w", n.ImportPath, err)
 		}
-		m := googleRepoRe.FindSubmatch(p)
+		m := googleRevisionPattern.FindSubmatch(data)
 		if m == nil {
-			return fmt.Errorf("cannot find VCS on Google Code project page")
+			return fmt.Errorf("cannot find revision in page: %s", n.ImportPath)
 		}
-		match["vcs"] = string(m[1])
-		n.Value = defaultTags[match["vcs"]]
-	}
-	match["tag"] = n.Value
-	data, err := com.HttpGetBytes(client, com.Expand("http://code.google.com/p/{repo}/source/browse/?repo={subrepo}&r={tag}", match), nil)
-	if err != nil {
-		return fmt.Errorf("fail to get revision(%s): %v", n.ImportPath, err)
-	}
-	m := googleRevisionPattern.FindSubmatch(data)
-	if m == nil {
-		return fmt.Errorf("cannot find revision in page: %s", n.ImportPath)
+		n.Revision = strings.TrimPrefix(string(m[0]), `_setViewedRevision('`)
 	}
-	n.Revision = strings.TrimPrefix(string(m[0]), `_setViewedRevision('`)
 	n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+".zip")
 	return nil
 }
@@ -103,9 +138,9 @@ func getGoogleArchive(client *http.Client, match map[string]string, n *Node) err
 		return fmt.Errorf("SVN not support yet")
 	} else {
 		// Downlaod archive.
-		if err := com.HttpGetToFile(client,
-			com.Expand("http://{subrepo}{dot}{repo}.googlecode.com/archive/{tag}.zip", match), nil, n.ArchivePath); err != nil {
-			return fmt.Errorf("fail to download archive(%s): %v", n.ImportPath, err)
+		if err := httpGetArchive(client,
+			com.Expand("http://{subrepo}{dot}{repo}.googlecode.com/archive/{tag}.zip", match), nil, n); err != nil {
+			return fmt.Errorf("fail to download archive(%s): %w", n.ImportPath, err)
 		}
 	}
 	return nil
@@ -16,14 +16,14 @@ package archive
 
 import (
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"path"
 	"regexp"
 	"strings"
+	"time"
 
-	"github.com/Unknwon/com"
 	"github.com/mcuadros/go-version"
+	"gopkg.in/ini.v1"
 
 	"github.com/gpmgo/switch/pkg/log"
 	"github.com/gpmgo/switch/pkg/setting"
@@ -31,83 +31,141 @@ import (
 
 var (
 	gopkgPathPattern = regexp.MustCompile(`^/(?:([a-zA-Z0-9][-a-zA-Z0-9]+)/)?([a-zA-Z][-.a-zA-Z0-9]*)\.((?:v0|v[1-9][0-9]*)(?:\.0|\.[1-9][0-9]*){0,2})(?:\.git)?((?:/[a-zA-Z0-9][-.a-zA-Z0-9]*)*)$`)
-	gopkgPattern     = regexp.MustCompile(`^gopkg\.in`)
 )
 
-func getGopkgRevision(client *http.Client, n *Node) error {
-	// Get real GitHub path.
-	m := gopkgPathPattern.FindStringSubmatch(strings.TrimPrefix(n.ImportPath, "gopkg.in"))
+// gopkgProvider represents gopkg.in, which redirects to repositories on GitHub.
+type gopkgProvider struct {
+	githubProvider
+}
+
+func newGopkgProvider(name string, sec *ini.Section) (Provider, error) {
+	return &gopkgProvider{newGithubBase(name, sec, "gopkg.in/")}, nil
+}
+
+func (p *gopkgProvider) RootPath(importPath string) string {
+	m := gopkgPathPattern.FindStringSubmatch("/" + strings.TrimPrefix(importPath, p.prefix))
 	if m == nil {
-		return fmt.Errorf("fail to match URL path")
+		return importPath
 	}
 	user := m[1]
-	name := m[2]
+	repo := m[2]
 	if len(user) == 0 {
-		user = "go-" + name
+		user = "go-" + repo
 	}
-	n.DownloadURL = path.Join("github.com", user, name)
+	return path.Join(p.prefix, user, repo+"."+m[3])
+}
 
-	if m[3] == "v0" {
-		m[3] = "master"
+// match returns submatches of gopkg.in path pattern for given import path.
+func (p *gopkgProvider) match(importPath string) ([]string, error) {
+	m := gopkgPathPattern.FindStringSubmatch("/" + strings.TrimPrefix(importPath, p.prefix))
+	if m == nil {
+		return nil, fmt.Errorf("fail to match URL path")
+	}
+	if len(m[1]) == 0 {
+		m[1] = "go-" + m[2]
 	}
+	return m, nil
+}
 
-	reqURL := "https://" + n.DownloadURL + ".git/info/refs?service=git-upload-pack"
-	log.Trace("Request URL: %s", reqURL)
+func (p *gopkgProvider) Download(client *http.Client, n *Node) error {
+	m, err := p.match(n.DownloadURL)
+	if err != nil {
+		return err
+	}
+	return p.download(client, n, m[1]+"/"+m[2])
+}
 
-	// Parse revision SHA by tag.
-	resp, err := http.Get(reqURL)
+func (p *gopkgProvider) ListRefs(client *http.Client, importPath string) (*Refs, error) {
+	m, err := p.match(importPath)
 	if err != nil {
-		return fmt.Errorf("fail to get response of refs: %v", err)
+		return nil, err
 	}
+	return getGitRefs(client, p.webURL+"/"+m[1]+"/"+m[2]+".git", nil)
+}
 
-	data, err := ioutil.ReadAll(resp.Body)
+func (p *gopkgProvider) FindCommit(client *http.Client, importPath, branch string, t time.Time) (*Commit, error) {
+	m, err := p.match(importPath)
 	if err != nil {
-		return fmt.Errorf("fail to read response data of refs: %v", err)
+		return nil, err
+	}
+	// Branch of major version is used by convention, e.g. "v2".
+	if len(branch) == 0 && m[3] != "v0" {
+		branch = m[3]
 	}
-	branchRef := "refs/heads/" + m[3]
-	tagRef := "refs/tags/" + m[3]
-	lines := strings.Split(string(data), "\n")
+	return p.findCommit(client, m[1]+"/"+m[2], branch, t)
+}
 
-	// Sort out all references and find the most latest and relevent one.
-	latestVersion := "0.0.0"
-	latestRevision := ""
-	for _, line := range lines {
-		// Filter non-version not same range version references.
-		if !strings.Contains(line, branchRef) && !strings.Contains(line, tagRef) {
-			continue
-		}
-		log.Trace(line)
+func (p *gopkgProvider) GetCommit(client *http.Client, importPath, sha string) (*Commit, error) {
+	m, err := p.match(importPath)
+	if err != nil {
+		return nil, err
+	}
+	return p.getCommit(client, m[1]+"/"+m[2], sha)
+}
 
-		// Extract exact version number.
-		refName := strings.TrimSuffix(line[45:], "^{}")
-		fields := strings.Split(refName, "/")
-		if len(fields) != 3 {
-			continue
+func (p *gopkgProvider) IsAncestor(client *http.Client, importPath, ancestor, sha string) (bool, error) {
+	m, err := p.match(importPath)
+	if err != nil {
+		return false, err
+	}
+	return p.isAncestor(client, m[1]+"/"+m[2], ancestor, sha)
+}
+
+func (p *gopkgProvider) GetRevision(client *http.Client, n *Node) error {
+	// Get real GitHub path.
+	m, err := p.match(n.ImportPath)
+	if err != nil {
+		return err
+	}
+
+	refs, err := getGitRefs(client, p.webURL+"/"+m[1]+"/"+m[2]+".git", nil)
+	if err != nil {
+		return err
+	}
+
+	// Exact revision is honored when given, e.g. a tag requested by module proxy.
+	if len(n.Value) > 0 {
+		sha, ok := refs.Resolve(n.Value)
+		if !ok {
+			if !IsSHA(n.Value) {
+				return fmt.Errorf("cannot find revision '%s' in refs: %s", n.Value, n.ImportPath)
+			}
+			sha = n.Value
 		}
+		n.Revision = sha
+		n.setTag(refs)
+		n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+p.ext)
+		return nil
+	}
+
+	major := m[3]
+	if major == "v0" {
+		major = "master"
+	}
 
-		if version.Compare(fields[2], latestVersion, ">") {
-			latestVersion = fields[2]
-			latestRevision = line[4:44]
+	// Sort out all references and find the most latest and relevent one.
+	latestVersion := "0.0.0"
+	latestRevision := ""
+	for _, names := range []map[string]string{refs.Branches, refs.Tags} {
+		for name, sha := range names {
+			// Filter non-version not same range version references.
+			if name != major && !strings.HasPrefix(name, major+".") {
+				continue
+			}
+			log.Trace("%s %s", sha, name)
+
+			if version.Compare(name, latestVersion, ">") {
+				latestVersion = name
+				latestRevision = sha
+			}
 		}
 	}
 
 	if len(latestRevision) == 0 {
-		return fmt.Errorf("cannot find revision in page: %s", n.ImportPath)
+		return fmt.Errorf("cannot find revision in refs: %s", n.ImportPath)
 	}
 
 	n.Revision = latestRevision
-	n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+".zip")
-	return nil
-}
-
-func getGopkgArchive(client *http.Client, match map[string]string, n *Node) error {
-	// We use .zip here.
-	// zip: https://github.com/{owner}/{repo}/archive/{sha}.zip
-
-	// Downlaod archive.
-	if err := com.HttpGetToFile(client,
-		fmt.Sprintf("https://%s/archive/%s.zip", n.DownloadURL, n.Revision), nil, n.ArchivePath); err != nil {
-		return fmt.Errorf("fail to download archive(%s): %v", n.ImportPath, err)
-	}
+	n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+p.ext)
 	return nil
 }
@@ -15,11 +15,20 @@
 package archive
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/Unknwon/com"
+
 	"github.com/gpmgo/switch/pkg/log"
 )
 
@@ -37,12 +46,27 @@ type transport struct {
 }
 
 func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Request must not be modified, so credential is applied to a copy.
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	u, c, err := authorize(r)
+	if err != nil {
+		return nil, err
+	}
+
 	timer := time.AfterFunc(*requestTimeout, func() {
-		t.t.CancelRequest(req)
-		log.Warn("Canceled request for %s, please interrupt the program.", req.URL)
+		t.t.CancelRequest(r)
+		log.Warn("Canceled request for %s, please interrupt the program.", r.URL)
 	})
 	defer timer.Stop()
-	resp, err := t.t.RoundTrip(req)
+	resp, err := t.t.RoundTrip(r)
+	if err == nil {
+		trackRateLimit(r, resp, u, c)
+	}
 	return resp, err
 }
 
@@ -55,3 +79,64 @@ var (
 	}
 	HttpClient = &http.Client{Transport: httpTransport}
 )
+
+// httpGetJSON gets the specified resource with given header and maps it to struct.
+func httpGetJSON(client *http.Client, url string, header http.Header, v interface{}) error {
+	data, err := com.HttpGetBytes(client, url, header)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("fail to decode JSON(%s): %v", url, err)
+	}
+	return nil
+}
+
+// isNotFound returns true if given error is caused by a 404 response.
+func isNotFound(err error) bool {
+	return strings.HasPrefix(err.Error(), "resource not found")
+}
+
+// httpGetArchive downloads archive of node from given URL. Response is written to a
+// temporary file in the same directory first, and moved to n.ArchivePath only when
+// it is complete and verified, so a failed download never leaves a truncated archive.
+func httpGetArchive(client *http.Client, url string, header http.Header, n *Node) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 404:
+		return fmt.Errorf("resource not found: %s", url)
+	case resp.StatusCode != 200:
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, url)
+	}
+
+	dir := filepath.Dir(n.ArchivePath)
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, resp.Body)
+	tmp.Close()
+	if err != nil {
+		return fmt.Errorf("fail to read response: %v", err)
+	} else if resp.ContentLength >= 0 && size != resp.ContentLength {
+		return fmt.Errorf("incomplete response: received %d of %d bytes", size, resp.ContentLength)
+	}
+	return n.saveArchive(tmp.Name())
+}
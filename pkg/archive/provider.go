@@ -0,0 +1,227 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/ini.v1"
+
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+var (
+	ErrNotMatchServicePattern = errors.New("cannot match package service prefix by given path")
+	ErrDateNotSupported       = errors.New("resolving revision by date is not supported by the service")
+	ErrCommitNotSupported     = errors.New("looking up commit is not supported by the service")
+	ErrAncestryNotSupported   = errors.New("checking commit ancestry is not supported by the service")
+
+	// ownerRepoPattern matches "{owner}/{repo}" part of import paths with prefix trimmed.
+	ownerRepoPattern = regexp.MustCompile(`^(?P<owner>[a-z0-9A-Z_.\-]+)/(?P<repo>[a-z0-9A-Z_.\-]+)(?P<dir>/[a-z0-9A-Z_.\-/]*)?$`)
+)
+
+// Provider represents an upstream source code hosting service.
+type Provider interface {
+	// Name returns name of the provider instance.
+	Name() string
+	// Match returns true if given import path is served by the provider.
+	Match(importPath string) bool
+	// RootPath returns repository root import path of given import path.
+	RootPath(importPath string) string
+	// Extension returns file extension of archives.
+	Extension() string
+	// ListRefs returns references of repository of given import path.
+	ListRefs(client *http.Client, importPath string) (*Refs, error)
+	// GetRevision resolves n.Value to a revision and sets n.Revision and n.ArchivePath.
+	GetRevision(client *http.Client, n *Node) error
+	// Download fetches archive of n.Revision and saves to n.ArchivePath.
+	Download(client *http.Client, n *Node) error
+}
+
+// Commit represents a commit in history of a repository.
+type Commit struct {
+	SHA     string
+	Time    time.Time // Commit time.
+	Author  string
+	Subject string // First line of commit message.
+}
+
+// commitSubject returns first line of given commit message.
+func commitSubject(message string) string {
+	return strings.TrimSpace(strings.SplitN(message, "\n", 2)[0])
+}
+
+// CommitFinder is implemented by providers that can look up commit history.
+type CommitFinder interface {
+	// FindCommit returns the last commit on given branch of repository of given
+	// import path at or before given time, empty branch means default branch.
+	FindCommit(client *http.Client, importPath, branch string, t time.Time) (*Commit, error)
+}
+
+// CommitGetter is implemented by providers that can look up commit metadata.
+type CommitGetter interface {
+	// GetCommit returns commit of given SHA in repository of given import path.
+	GetCommit(client *http.Client, importPath, sha string) (*Commit, error)
+}
+
+// AncestryChecker is implemented by providers that can check commit ancestry.
+type AncestryChecker interface {
+	// IsAncestor returns true if commit ancestor is an ancestor of or same as
+	// commit sha in repository of given import path.
+	IsAncestor(client *http.Client, importPath, ancestor, sha string) (bool, error)
+}
+
+// checkCommitTime returns error if commit is after given time,
+// which happens when the service ignores time parameter of commits API.
+func checkCommitTime(c *Commit, t time.Time) error {
+	if c.Time.After(t) {
+		return ErrDateNotSupported
+	}
+	return nil
+}
+
+// ProviderFactory creates a provider by given name and configuration section.
+type ProviderFactory func(name string, sec *ini.Section) (Provider, error)
+
+var (
+	// providerTypes is the list of provider types can be used in configuration.
+	providerTypes = map[string]ProviderFactory{
+		"github":    newGithubProvider,
+		"golang":    newGolangProvider,
+		"google":    newGoogleProvider,
+		"bitbucket": newBitbucketProvider,
+		"gopkg":     newGopkgProvider,
+		"gitlab":    newGitlabProvider,
+		"gitea":     newGiteaProvider,
+	}
+
+	providersLock sync.RWMutex
+	providers     []Provider
+)
+
+// RegisterProvider adds a provider to the registry,
+// it panics if a provider with same name already exists.
+func RegisterProvider(p Provider) {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+
+	for _, prev := range providers {
+		if prev.Name() == p.Name() {
+			panic("archive: duplicated provider " + p.Name())
+		}
+	}
+	providers = append(providers, p)
+}
+
+// Providers returns all registered providers.
+func Providers() []Provider {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+	return providers
+}
+
+// MatchProvider returns the first provider that serves given import path.
+func MatchProvider(importPath string) Provider {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+
+	for _, p := range providers {
+		if p.Match(importPath) {
+			return p
+		}
+	}
+	return nil
+}
+
+// baseProvider implements common methods of providers with import path prefix.
+type baseProvider struct {
+	name   string
+	prefix string
+	depth  int
+	ext    string
+}
+
+func newBaseProvider(name string, sec *ini.Section, prefix string, depth int) baseProvider {
+	return baseProvider{
+		name:   name,
+		prefix: sec.Key("PREFIX").MustString(prefix),
+		depth:  sec.Key("DEPTH").MustInt(depth),
+		ext:    sec.Key("EXTENSION").MustString(".zip"),
+	}
+}
+
+func (p *baseProvider) Name() string {
+	return p.name
+}
+
+func (p *baseProvider) Match(importPath string) bool {
+	return strings.HasPrefix(importPath, p.prefix)
+}
+
+func (p *baseProvider) RootPath(importPath string) string {
+	return joinPath(importPath, p.depth)
+}
+
+func (p *baseProvider) Extension() string {
+	return p.ext
+}
+
+// matchPattern returns named submatches of given pattern in s.
+func matchPattern(pattern *regexp.Regexp, s string) (map[string]string, error) {
+	m := pattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, ErrNotMatchServicePattern
+	}
+
+	match := map[string]string{"downloadURL": s}
+	for i, n := range pattern.SubexpNames() {
+		if n != "" {
+			match[n] = m[i]
+		}
+	}
+	return match, nil
+}
+
+// loadProviders creates and registers providers from "provider.*" sections of configuration.
+func loadProviders() {
+	for _, sec := range setting.Cfg.Sections() {
+		if !strings.HasPrefix(sec.Name(), "provider.") || !sec.Key("ENABLED").MustBool(true) {
+			continue
+		}
+		name := strings.TrimPrefix(sec.Name(), "provider.")
+
+		typ := sec.Key("TYPE").MustString(name)
+		newProvider, ok := providerTypes[typ]
+		if !ok {
+			log.Fatal(4, "Unknown type '%s' of provider '%s'", typ, name)
+		}
+		p, err := newProvider(name, sec)
+		if err != nil {
+			log.Fatal(4, "Fail to create provider '%s': %v", name, err)
+		}
+		RegisterProvider(p)
+		log.Trace("Provider registered: %s", name)
+	}
+}
+
+func init() {
+	loadProviders()
+}
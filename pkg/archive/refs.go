@@ -0,0 +1,247 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gpmgo/switch/pkg/log"
+)
+
+var (
+	ErrInvalidPktLine = errors.New("invalid pkt-line")
+
+	shaPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+)
+
+// Refs represents references advertised by a git repository.
+type Refs struct {
+	Head     string            // SHA of HEAD.
+	Branches map[string]string // Branch name -> SHA.
+	Tags     map[string]string // Tag name -> SHA of the commit it points to.
+}
+
+// IsSHA returns true if given string is a full SHA.
+func IsSHA(s string) bool {
+	return shaPattern.MatchString(s)
+}
+
+// Resolve returns SHA of given branch, tag or full ref name.
+// Branches take precedence over tags with same name as git does.
+func (refs *Refs) Resolve(name string) (string, bool) {
+	switch {
+	case len(name) == 0 || name == "HEAD":
+		return refs.Head, len(refs.Head) > 0
+	case strings.HasPrefix(name, "refs/heads/"):
+		sha, ok := refs.Branches[strings.TrimPrefix(name, "refs/heads/")]
+		return sha, ok
+	case strings.HasPrefix(name, "refs/tags/"):
+		sha, ok := refs.Tags[strings.TrimPrefix(name, "refs/tags/")]
+		return sha, ok
+	}
+
+	if sha, ok := refs.Branches[name]; ok {
+		return sha, true
+	}
+	sha, ok := refs.Tags[name]
+	return sha, ok
+}
+
+// IsTag returns true if given name resolves to a tag,
+// which is not shadowed by a branch with same name.
+func (refs *Refs) IsTag(name string) bool {
+	switch {
+	case strings.HasPrefix(name, "refs/tags/"):
+		_, ok := refs.Tags[strings.TrimPrefix(name, "refs/tags/")]
+		return ok
+	case len(name) == 0 || name == "HEAD" || strings.HasPrefix(name, "refs/heads/"):
+		return false
+	}
+
+	if _, ok := refs.Branches[name]; ok {
+		return false
+	}
+	_, ok := refs.Tags[name]
+	return ok
+}
+
+// readPktLine reads a pkt-line from data, and returns its payload and rest of data.
+// Payload is nil for a flush-pkt.
+func readPktLine(data []byte) (payload, rest []byte, err error) {
+	if len(data) < 4 {
+		return nil, nil, ErrInvalidPktLine
+	}
+	size, err := strconv.ParseUint(string(data[:4]), 16, 16)
+	if err != nil {
+		return nil, nil, ErrInvalidPktLine
+	}
+	if size == 0 {
+		return nil, data[4:], nil
+	} else if size < 4 || int(size) > len(data) {
+		return nil, nil, ErrInvalidPktLine
+	}
+	return data[4:size], data[size:], nil
+}
+
+// ParseRefs parses reference advertisement of git smart HTTP protocol.
+// Both the "service=git-upload-pack" response of smart HTTP servers and
+// plain text "info/refs" of dumb servers are supported.
+func ParseRefs(data []byte) (*Refs, error) {
+	refs := &Refs{
+		Branches: make(map[string]string),
+		Tags:     make(map[string]string),
+	}
+
+	var lines [][]byte
+	if len(data) >= 4 && bytes.HasPrefix(data[4:], []byte("# service=")) {
+		for len(data) > 0 {
+			payload, rest, err := readPktLine(data)
+			if err != nil {
+				return nil, err
+			}
+			if payload != nil && !bytes.HasPrefix(payload, []byte("# service=")) {
+				lines = append(lines, payload)
+			}
+			data = rest
+		}
+	} else {
+		lines = bytes.Split(data, []byte("\n"))
+	}
+
+	peeled := make(map[string]string)
+	for _, line := range lines {
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		// Capabilities are appended to the first reference after a NUL byte.
+		if i := bytes.IndexByte(line, 0); i > -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) != 2 || !IsSHA(fields[0]) {
+			continue
+		}
+		sha, name := fields[0], fields[1]
+
+		switch {
+		case name == "HEAD":
+			refs.Head = sha
+		case strings.HasPrefix(name, "refs/heads/"):
+			refs.Branches[strings.TrimPrefix(name, "refs/heads/")] = sha
+		case strings.HasPrefix(name, "refs/tags/"):
+			name = strings.TrimPrefix(name, "refs/tags/")
+			if strings.HasSuffix(name, "^{}") {
+				peeled[strings.TrimSuffix(name, "^{}")] = sha
+			} else {
+				refs.Tags[name] = sha
+			}
+		}
+	}
+
+	// Annotated tags point to tag objects, use SHA of the commit they peel to.
+	for name, sha := range peeled {
+		refs.Tags[name] = sha
+	}
+	return refs, nil
+}
+
+// getGitRefs fetches and parses references of given git repository URL with given header.
+func getGitRefs(client *http.Client, repoURL string, header http.Header) (*Refs, error) {
+	reqURL := strings.TrimSuffix(repoURL, "/") + "/info/refs?service=git-upload-pack"
+	log.Trace("Request URL: %s", reqURL)
+
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get response of refs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("fail to get refs(%s): status code %d", repoURL, resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read response data of refs: %v", err)
+	}
+	refs, err := ParseRefs(data)
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse refs(%s): %v", repoURL, err)
+	}
+	return refs, nil
+}
+
+// getGitRevision resolves revision of node by references of given git repository URL.
+// A full SHA is accepted as it is because it cannot be looked up in references.
+func getGitRevision(client *http.Client, n *Node, repoURL string) error {
+	if IsSHA(n.Value) {
+		n.Revision = n.Value
+		return nil
+	}
+
+	refs, err := getGitRefs(client, repoURL, nil)
+	if err != nil {
+		return err
+	}
+	sha, ok := refs.Resolve(n.Value)
+	if !ok {
+		return fmt.Errorf("cannot find revision '%s' in refs: %s", n.Value, n.ImportPath)
+	}
+	n.Revision = sha
+	n.setTag(refs)
+	return nil
+}
+
+// resolveByRefs resolves revision of node by references returned by given function,
+// and records tag it is resolved from. It returns false if n.Value is not found in
+// references, which may still be resolved through API of the service.
+func resolveByRefs(n *Node, listRefs func() (*Refs, error)) (bool, error) {
+	if IsSHA(n.Value) {
+		n.Revision = n.Value
+		return true, nil
+	}
+
+	refs, err := listRefs()
+	if err != nil {
+		return false, err
+	}
+	sha, ok := refs.Resolve(n.Value)
+	if !ok {
+		return false, nil
+	}
+	n.Revision = sha
+	n.setTag(refs)
+	return true, nil
+}
+
+// setTag records n.Value as the tag revision is resolved from if it is a tag in refs.
+func (n *Node) setTag(refs *Refs) {
+	if refs.IsTag(n.Value) {
+		n.Tag = strings.TrimPrefix(n.Value, "refs/tags/")
+		n.Immutable = true
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"fmt"
+	"testing"
+)
+
+// pkt returns given lines encoded as pkt-lines, empty string stands for a flush-pkt.
+func pkt(lines ...string) []byte {
+	var data []byte
+	for _, line := range lines {
+		if len(line) == 0 {
+			data = append(data, "0000"...)
+			continue
+		}
+		data = append(data, fmt.Sprintf("%04x%s", len(line)+4, line)...)
+	}
+	return data
+}
+
+const (
+	shaHead    = "8f3c8e5a3b1c4a1d2e6f7a8b9c0d1e2f3a4b5c6d"
+	shaDevelop = "1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d"
+	shaTagObj  = "c0ffee00c0ffee00c0ffee00c0ffee00c0ffee00"
+	shaTagPeel = "ab12cd34ef56ab12cd34ef56ab12cd34ef56ab12"
+	shaLight   = "fedcba9876543210fedcba9876543210fedcba98"
+)
+
+// recordedRefs is reference advertisement recorded from a smart HTTP server
+// (GitHub), SHAs are replaced.
+var recordedRefs = pkt(
+	"# service=git-upload-pack\n",
+	"",
+	shaHead+" HEAD\x00multi_ack thin-pack side-band side-band-64k ofs-delta shallow deepen-since deepen-not deepen-relative no-progress include-tag multi_ack_detailed allow-tip-sha1-in-want allow-reachable-sha1-in-want no-done symref=HEAD:refs/heads/master filter object-format=sha1 agent=git/github-g2faa3ea5fe2e\n",
+	shaDevelop+" refs/heads/develop\n",
+	shaHead+" refs/heads/master\n",
+	shaHead+" refs/pull/1/head\n",
+	shaTagObj+" refs/tags/v1.0.0\n",
+	shaTagPeel+" refs/tags/v1.0.0^{}\n",
+	shaLight+" refs/tags/v1.1.0\n",
+	"",
+)
+
+func TestParseRefs(t *testing.T) {
+	refs, err := ParseRefs(recordedRefs)
+	if err != nil {
+		t.Fatalf("ParseRefs: %v", err)
+	}
+
+	if refs.Head != shaHead {
+		t.Errorf("HEAD = %q, want %q (capabilities after NUL must be stripped)", refs.Head, shaHead)
+	}
+	if len(refs.Branches) != 2 || refs.Branches["master"] != shaHead || refs.Branches["develop"] != shaDevelop {
+		t.Errorf("branches = %v", refs.Branches)
+	}
+	if refs.Tags["v1.0.0"] != shaTagPeel {
+		t.Errorf("annotated tag = %q, want peeled commit %q", refs.Tags["v1.0.0"], shaTagPeel)
+	}
+	if refs.Tags["v1.1.0"] != shaLight {
+		t.Errorf("lightweight tag = %q, want %q", refs.Tags["v1.1.0"], shaLight)
+	}
+	if _, ok := refs.Tags["v1.0.0^{}"]; ok {
+		t.Error("peeled reference is recorded as a tag")
+	}
+	if len(refs.Tags) != 2 {
+		t.Errorf("tags = %v", refs.Tags)
+	}
+}
+
+func TestParseRefsPeeledFirst(t *testing.T) {
+	// Order of references must not matter.
+	refs, err := ParseRefs(pkt(
+		"# service=git-upload-pack\n",
+		"",
+		shaTagPeel+" refs/tags/v1.0.0^{}\n",
+		shaTagObj+" refs/tags/v1.0.0\n",
+		"",
+	))
+	if err != nil {
+		t.Fatalf("ParseRefs: %v", err)
+	}
+	if refs.Tags["v1.0.0"] != shaTagPeel {
+		t.Errorf("annotated tag = %q, want peeled commit %q", refs.Tags["v1.0.0"], shaTagPeel)
+	}
+}
+
+func TestParseRefsDumb(t *testing.T) {
+	refs, err := ParseRefs([]byte(shaHead + "\trefs/heads/master\n" +
+		shaTagObj + "\trefs/tags/v1.0.0\n" +
+		shaTagPeel + "\trefs/tags/v1.0.0^{}\n"))
+	if err != nil {
+		t.Fatalf("ParseRefs: %v", err)
+	}
+	if refs.Branches["master"] != shaHead {
+		t.Errorf("master = %q, want %q", refs.Branches["master"], shaHead)
+	}
+	if refs.Tags["v1.0.0"] != shaTagPeel {
+		t.Errorf("annotated tag = %q, want peeled commit %q", refs.Tags["v1.0.0"], shaTagPeel)
+	}
+}
+
+func TestParseRefsMalformed(t *testing.T) {
+	valid := pkt("# service=git-upload-pack\n", "", shaHead+" HEAD\n")
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated length", []byte("001e# service=git-upload-pack\n000")},
+		{"truncated payload", valid[:len(valid)-10]},
+		{"invalid length", append(pkt("# service=git-upload-pack\n", ""), "zzzz"+shaHead+" HEAD\n"...)},
+		{"length shorter than header", append(pkt("# service=git-upload-pack\n", ""), "0003"...)},
+		{"length longer than data", append(pkt("# service=git-upload-pack\n", ""), "ffff"+shaHead...)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("ParseRefs panics: %v", r)
+				}
+			}()
+			if _, err := ParseRefs(c.data); err != ErrInvalidPktLine {
+				t.Errorf("err = %v, want %v", err, ErrInvalidPktLine)
+			}
+		})
+	}
+}
+
+func TestReadPktLine(t *testing.T) {
+	payload, rest, err := readPktLine([]byte("0000000aabcdef"))
+	if err != nil || payload != nil || string(rest) != "000aabcdef" {
+		t.Fatalf("flush-pkt: payload = %q, rest = %q, err = %v", payload, rest, err)
+	}
+	payload, rest, err = readPktLine(rest)
+	if err != nil || string(payload) != "abcdef" || len(rest) != 0 {
+		t.Fatalf("data-pkt: payload = %q, rest = %q, err = %v", payload, rest, err)
+	}
+	if _, _, err = readPktLine([]byte("00")); err != ErrInvalidPktLine {
+		t.Errorf("short data: err = %v, want %v", err, ErrInvalidPktLine)
+	}
+}
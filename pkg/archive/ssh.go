@@ -0,0 +1,162 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+var gitTimeout = flag.Duration("git_timeout", 5*time.Minute, "Time out for running a git command.")
+
+// sshRepoURL returns SSH URL of repository of node.
+func (n *Node) sshRepoURL() string {
+	username := n.Credential.Username
+	if len(username) == 0 {
+		username = "git"
+	}
+	return fmt.Sprintf("ssh://%s@%s/%s.git", username, n.Credential.Host,
+		strings.TrimPrefix(n.DownloadURL, n.Credential.Host+"/"))
+}
+
+// runGit executes git command in given directory with SSH private key of node.
+func (n *Node) runGit(dir string, args ...string) ([]byte, error) {
+	keyFile, err := ioutil.TempFile("", "switch-key")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(keyFile.Name())
+	_, err = keyFile.WriteString(strings.TrimSpace(n.Credential.Secret) + "\n")
+	keyFile.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// Hung SSH handshakes or fetches are killed, so that callers waiting
+	// for the fetch and the lease held for it are released.
+	ctx, cancel := context.WithTimeout(context.Background(), *gitTimeout)
+	defer cancel()
+
+	log.Trace("Run git: %s", strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, "git", args...)
+	// SSH started by git may keep output open after git is killed.
+	cmd.WaitDelay = 10 * time.Second
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0",
+		"GIT_SSH_COMMAND=ssh -i "+keyFile.Name()+" -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new -o BatchMode=yes")
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	stdout, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("git %s timed out after %s", args[0], *gitTimeout)
+	} else if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout, nil
+}
+
+// listSSHRefs returns references of repository of node listed through SSH.
+func (n *Node) listSSHRefs() (*Refs, error) {
+	data, err := n.runGit("", "ls-remote", n.sshRepoURL())
+	if err != nil {
+		return nil, fmt.Errorf("fail to list remote refs(%s): %v", n.ImportPath, err)
+	}
+	refs, err := ParseRefs(data)
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse refs(%s): %v", n.ImportPath, err)
+	}
+	return refs, nil
+}
+
+// getSSHRevision resolves revision of node by references listed through SSH.
+func (n *Node) getSSHRevision() error {
+	if !IsSHA(n.Value) {
+		refs, err := n.listSSHRefs()
+		if err != nil {
+			return err
+		}
+		sha, ok := refs.Resolve(n.Value)
+		if !ok {
+			return fmt.Errorf("cannot find revision '%s' in refs: %s", n.Value, n.ImportPath)
+		}
+		n.Revision = sha
+		n.setTag(refs)
+	} else {
+		n.Revision = n.Value
+	}
+	n.ArchivePath = path.Join(setting.ArchivePath, n.ImportPath, n.Revision+GetExtension(n.ImportPath))
+	return nil
+}
+
+// downloadSSH fetches revision of node through SSH and saves as zip archive.
+func (n *Node) downloadSSH() error {
+	dir, err := ioutil.TempDir("", "switch-git")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err = n.runGit(dir, "init", "--bare", "-q"); err != nil {
+		return fmt.Errorf("fail to init repository(%s): %v", n.ImportPath, err)
+	}
+	if _, err = n.runGit(dir, "fetch", "-q", "--depth", "1", n.sshRepoURL(), n.Revision); err != nil {
+		return fmt.Errorf("fail to fetch revision(%s): %v", n.ImportPath, err)
+	}
+
+	// Commit metadata cannot be looked up later without fetching again.
+	data, err := n.runGit(dir, "log", "-1", "--format=%ct%x00%an%x00%s", n.Revision)
+	if err != nil {
+		return fmt.Errorf("fail to get commit(%s): %v", n.ImportPath, err)
+	}
+	if fields := strings.SplitN(strings.TrimSpace(string(data)), "\x00", 3); len(fields) == 3 {
+		unix, _ := strconv.ParseInt(fields[0], 10, 64)
+		n.Commit = &Commit{
+			SHA:     n.Revision,
+			Time:    time.Unix(unix, 0),
+			Author:  fields[1],
+			Subject: fields[2],
+		}
+	}
+
+	// Keep same layout as archives of hosting services that have a top directory.
+	archiveDir, err := filepath.Abs(filepath.Dir(n.ArchivePath))
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(archiveDir, os.ModePerm); err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(archiveDir, ".download-"+n.Revision)
+	defer os.Remove(tmpPath)
+	if _, err = n.runGit(dir, "archive", "--format=zip",
+		"--prefix="+path.Base(n.ImportPath)+"-"+n.Revision+"/",
+		"-o", tmpPath, n.Revision); err != nil {
+		return fmt.Errorf("fail to archive revision(%s): %v", n.ImportPath, err)
+	}
+	return n.saveArchive(tmpPath)
+}
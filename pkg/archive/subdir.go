@@ -0,0 +1,121 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidSubdir = errors.New("invalid subdirectory")
+
+// GetSubdir returns directory of given import path relative to its project root,
+// it returns empty string for project root.
+func GetSubdir(name string) string {
+	root := GetRootPath(name)
+	if !strings.HasPrefix(name, root+"/") {
+		return ""
+	}
+	return path.Clean(name[len(root)+1:])
+}
+
+// copyZipFile copies given file to zip writer.
+func copyZipFile(zw *zip.Writer, f *zip.File) error {
+	header := f.FileHeader
+	w, err := zw.CreateHeader(&header)
+	if err != nil || f.Mode().IsDir() {
+		return err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+// CheckSubdir returns error if given subdirectory is not a clean relative path inside archive.
+func CheckSubdir(subdir string) error {
+	if len(subdir) == 0 || subdir != path.Clean(subdir) || subdir == "." ||
+		subdir == ".." || strings.HasPrefix(subdir, "../") || path.IsAbs(subdir) {
+		return ErrInvalidSubdir
+	}
+	return nil
+}
+
+// ExtractSubdir saves files in given subdirectory of archive at given path to dst.
+// Top-level directory that upstream services put in archives is kept, so the
+// extracted archive has same layout as the original one.
+func ExtractSubdir(archivePath, dst, subdir string) (err error) {
+	if err = CheckSubdir(subdir); err != nil {
+		return err
+	}
+
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	dir, err := filepath.Abs(filepath.Dir(dst))
+	if err != nil {
+		return err
+	} else if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	fw, err := ioutil.TempFile(dir, ".extract-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		fw.Close()
+		if err != nil {
+			os.Remove(fw.Name())
+		}
+	}()
+
+	count := 0
+	zw := zip.NewWriter(fw)
+	for _, f := range r.File {
+		i := strings.Index(f.Name, "/")
+		if i == -1 || !strings.HasPrefix(f.Name[i+1:], subdir+"/") {
+			continue
+		}
+
+		if err = copyZipFile(zw, f); err != nil {
+			return err
+		}
+		count++
+	}
+	if count == 0 {
+		return fmt.Errorf("cannot find directory '%s' in archive", subdir)
+	}
+
+	if err = zw.Close(); err != nil {
+		return err
+	} else if err = fw.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fw.Name(), dst)
+}
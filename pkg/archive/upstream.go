@@ -0,0 +1,301 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+// credential represents a way of authenticating to an upstream host,
+// and the rate limit quota it has been observed to have.
+type credential struct {
+	name     string // Masked for display.
+	token    string
+	username string
+	password string
+
+	limit     int // -1 if unknown.
+	remaining int
+	reset     time.Time
+}
+
+func (c *credential) apply(req *http.Request, header, scheme string) {
+	switch {
+	case len(c.token) > 0:
+		if len(scheme) > 0 {
+			req.Header.Set(header, scheme+" "+c.token)
+		} else {
+			req.Header.Set(header, c.token)
+		}
+	case len(c.username) > 0:
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// exhausted returns true if quota of credential is nearly gone and not reset yet.
+func (c *credential) exhausted(now time.Time) bool {
+	return c.limit >= 0 && c.remaining <= upstreamCfg.threshold && now.Before(c.reset)
+}
+
+// upstream represents credentials of an upstream host, they are used in
+// order and the anonymous one is always the last as fallback.
+type upstream struct {
+	host   string
+	header string
+	scheme string
+
+	lock  sync.Mutex
+	creds []*credential
+}
+
+func newUpstream(host string) *upstream {
+	return &upstream{
+		host:   host,
+		header: "Authorization",
+		scheme: "token",
+	}
+}
+
+func (u *upstream) addCredential(c *credential) {
+	c.limit = -1
+	u.creds = append(u.creds, c)
+}
+
+// RateLimitError is returned when rate limit of an upstream host is exhausted.
+type RateLimitError struct {
+	Host  string
+	Until time.Time // When the earliest quota is reset.
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit of %s is exhausted until %s", e.Host, e.Until.UTC().Format(time.RFC3339))
+}
+
+// RetryAfter returns how long to wait before retrying if given error is caused
+// by exhausted rate limit of an upstream host.
+func RetryAfter(err error) (time.Duration, bool) {
+	var e *RateLimitError
+	if !errors.As(err, &e) {
+		return 0, false
+	}
+	wait := e.Until.Sub(time.Now())
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait, true
+}
+
+// pick returns the first credential still has quota. When all quotas are nearly
+// gone, it returns error until the earliest reset, so that requests fail fast
+// instead of waiting for it.
+func (u *upstream) pick() (*credential, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	now := time.Now()
+	earliest := u.creds[0]
+	for _, c := range u.creds {
+		if !c.exhausted(now) {
+			return c, nil
+		}
+		if c.reset.Before(earliest.reset) {
+			earliest = c
+		}
+	}
+	log.Warn("Rate limit of %s is exhausted until %s", u.host, earliest.reset.Format(time.RFC3339))
+	return nil, &RateLimitError{u.host, earliest.reset}
+}
+
+// update records rate limit quota of credential from response headers.
+func (u *upstream) update(c *credential, resp *http.Response) {
+	remaining, err := strconv.Atoi(rateLimitHeader(resp.Header, "Remaining"))
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(rateLimitHeader(resp.Header, "Limit"))
+	reset, _ := strconv.ParseInt(rateLimitHeader(resp.Header, "Reset"), 10, 64)
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	c.limit = limit
+	c.remaining = remaining
+	c.reset = time.Unix(reset, 0)
+}
+
+// rateLimitHeader returns value of rate limit header with given name,
+// both "X-RateLimit-*" (GitHub, Bitbucket) and "RateLimit-*" (GitLab) are recognized.
+func rateLimitHeader(header http.Header, name string) string {
+	if v := header.Get("X-RateLimit-" + name); len(v) > 0 {
+		return v
+	}
+	return header.Get("RateLimit-" + name)
+}
+
+var upstreamCfg struct {
+	threshold int
+}
+
+var (
+	upstreamsLock sync.RWMutex
+	upstreams     = make(map[string]*upstream)
+)
+
+// getUpstream returns upstream of given host, or nil if it does not exist.
+func getUpstream(host string) *upstream {
+	upstreamsLock.RLock()
+	defer upstreamsLock.RUnlock()
+	return upstreams[host]
+}
+
+// authorize applies a credential to request if the request does not carry one,
+// and returns the upstream and credential to record rate limit quota.
+func authorize(req *http.Request) (*upstream, *credential, error) {
+	u := getUpstream(req.URL.Host)
+	if u == nil || len(req.Header.Get(u.header)) > 0 {
+		return nil, nil, nil
+	}
+	c, err := u.pick()
+	if err != nil {
+		return nil, nil, err
+	}
+	c.apply(req, u.header, u.scheme)
+	return u, c, nil
+}
+
+// trackRateLimit records rate limit quota from response of request. Quota of host
+// without configured credentials is recorded as its default one.
+func trackRateLimit(req *http.Request, resp *http.Response, u *upstream, c *credential) {
+	if u == nil {
+		if len(rateLimitHeader(resp.Header, "Remaining")) == 0 || getUpstream(req.URL.Host) != nil {
+			return
+		}
+
+		upstreamsLock.Lock()
+		if u = upstreams[req.URL.Host]; u == nil {
+			u = newUpstream(req.URL.Host)
+			u.addCredential(&credential{name: "default"})
+			upstreams[u.host] = u
+		}
+		upstreamsLock.Unlock()
+		c = u.creds[0]
+	}
+	u.update(c, resp)
+}
+
+// maskSecret returns a display form of secret that only reveals last 4 characters.
+func maskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
+
+// RateLimit represents observed rate limit quota of an upstream credential.
+type RateLimit struct {
+	Host       string
+	Credential string
+	Limit      int // -1 if unknown.
+	Remaining  int
+	Reset      time.Time
+}
+
+// RateLimits returns rate limit quotas of all upstream credentials.
+func RateLimits() []*RateLimit {
+	upstreamsLock.RLock()
+	hosts := make([]string, 0, len(upstreams))
+	for host := range upstreams {
+		hosts = append(hosts, host)
+	}
+	upstreamsLock.RUnlock()
+	sort.Strings(hosts)
+
+	limits := make([]*RateLimit, 0, len(hosts))
+	for _, host := range hosts {
+		u := getUpstream(host)
+		u.lock.Lock()
+		for _, c := range u.creds {
+			limits = append(limits, &RateLimit{
+				Host:       host,
+				Credential: c.name,
+				Limit:      c.limit,
+				Remaining:  c.remaining,
+				Reset:      c.reset,
+			})
+		}
+		u.lock.Unlock()
+	}
+	return limits
+}
+
+// loadUpstreams reads credentials from "upstream.<host>" sections of configuration.
+func loadUpstreams() {
+	sec := setting.Cfg.Section("upstream")
+	upstreamCfg.threshold = sec.Key("RATE_LIMIT_THRESHOLD").MustInt(10)
+
+	for _, sec := range setting.Cfg.Sections() {
+		if !strings.HasPrefix(sec.Name(), "upstream.") {
+			continue
+		}
+		u := newUpstream(strings.TrimPrefix(sec.Name(), "upstream."))
+		u.header = sec.Key("HEADER").MustString(u.header)
+		if sec.HasKey("SCHEME") {
+			u.scheme = sec.Key("SCHEME").String()
+		}
+		for _, token := range sec.Key("TOKENS").Strings(",") {
+			u.addCredential(&credential{name: "token " + maskSecret(token), token: token})
+		}
+		if username := sec.Key("USERNAME").String(); len(username) > 0 {
+			u.addCredential(&credential{
+				name:     "user " + username,
+				username: username,
+				password: sec.Key("PASSWORD").String(),
+			})
+		}
+		upstreams[u.host] = u
+		log.Trace("Upstream credentials loaded: %s", u.host)
+	}
+
+	// OAuth application of GitHub has its own quota, use it after configured tokens.
+	if len(setting.GithubClientID) > 0 {
+		u, ok := upstreams["api.github.com"]
+		if !ok {
+			u = newUpstream("api.github.com")
+			upstreams[u.host] = u
+		}
+		u.addCredential(&credential{
+			name:     "client " + setting.GithubClientID,
+			username: setting.GithubClientID,
+			password: setting.GithubClientSecret,
+		})
+	}
+
+	for _, u := range upstreams {
+		u.addCredential(&credential{name: "anonymous"})
+	}
+}
+
+func init() {
+	loadUpstreams()
+}
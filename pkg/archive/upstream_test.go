@@ -0,0 +1,76 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpstreamRateLimited(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("X-RateLimit-Limit", "60")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(time.Hour).Unix()))
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	// Quota of host without configured credentials is tracked from first response.
+	if _, err := HttpClient.Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	host := strings.TrimPrefix(srv.URL, "http://")
+	defer func() {
+		upstreamsLock.Lock()
+		delete(upstreams, host)
+		upstreamsLock.Unlock()
+	}()
+
+	start := time.Now()
+	_, err := HttpClient.Get(srv.URL)
+	if err == nil {
+		t.Fatal("request is sent while rate limit is exhausted")
+	} else if time.Since(start) > time.Second {
+		t.Errorf("request took %s to fail", time.Since(start))
+	}
+	if requests != 1 {
+		t.Errorf("requests = %d, want 1", requests)
+	}
+
+	var e *RateLimitError
+	if !errors.As(err, &e) || e.Host != host {
+		t.Fatalf("err = %v, want rate limit error of %s", err, host)
+	}
+
+	// Errors are wrapped by providers before they reach routes.
+	wait, ok := RetryAfter(fmt.Errorf("fail to get revision(example.com/repo): %w", err))
+	if !ok || wait < 59*time.Minute || wait > time.Hour {
+		t.Errorf("RetryAfter = %s, %v, want about an hour", wait, ok)
+	}
+	if _, ok = RetryAfter(fmt.Errorf("rate limit of %s is exhausted until %s", host, e.Until.Format(time.RFC3339))); ok {
+		t.Error("RetryAfter is true for error only has same message")
+	}
+	if _, ok = RetryAfter(fmt.Errorf("resource not found")); ok {
+		t.Error("RetryAfter is true for unrelated error")
+	}
+}
@@ -15,11 +15,16 @@
 package base
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"math"
@@ -52,6 +57,56 @@ func GetRandomString(n int, alphabets ...byte) string {
 	return string(bytes)
 }
 
+var ErrSecretKeyNotSet = errors.New("[security] SECRET_KEY must be set to encrypt or decrypt credentials")
+
+// newSecretCipher returns AES-GCM cipher with key derived from secret key of application.
+// Default secret key is public, so it is refused.
+func newSecretCipher() (cipher.AEAD, error) {
+	if !setting.HasSecretKey {
+		return nil, ErrSecretKeyNotSet
+	}
+	key := sha256.Sum256([]byte(setting.SecretKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptSecret encrypts given text with secret key of application,
+// and returns base64 encoded nonce and ciphertext.
+func EncryptSecret(text string) (string, error) {
+	gcm, err := newSecretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(text), nil)), nil
+}
+
+// DecryptSecret decrypts text encrypted by EncryptSecret.
+func DecryptSecret(text string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newSecretCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("ciphertext is too short")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
 // http://code.google.com/p/go/source/browse/pbkdf2/pbkdf2.go?repo=crypto
 func PBKDF2(password, salt []byte, iter, keyLen int, h func() hash.Hash) []byte {
 	prf := hmac.New(h, password)
@@ -0,0 +1,163 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package middleware
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/storage"
+)
+
+// ArchiveValidators represents validators of an archive derived from its content.
+type ArchiveValidators struct {
+	Sha256    string    // Hex-encoded SHA-256 checksum of archive.
+	ModTime   time.Time // Zero if unknown.
+	Immutable bool      // Addressed by full commit SHA, so content never changes.
+	Private   bool      // Must not be stored by shared caches.
+}
+
+// etag returns strong ETag of archive, empty if checksum is unknown.
+func (v *ArchiveValidators) etag() string {
+	if v == nil || len(v.Sha256) == 0 {
+		return ""
+	}
+	return `"` + v.Sha256 + `"`
+}
+
+// setHeaders sets ETag, Digest, Last-Modified and Cache-Control of archive.
+func (v *ArchiveValidators) setHeaders(h http.Header) {
+	etag := v.etag()
+	if len(etag) == 0 {
+		return
+	}
+	h.Set("ETag", etag)
+	if sum, err := hex.DecodeString(v.Sha256); err == nil {
+		h.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum))
+	}
+	if !v.ModTime.IsZero() {
+		h.Set("Last-Modified", v.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	scope := "public"
+	if v.Private {
+		scope = "private"
+	}
+	if v.Immutable {
+		h.Set("Cache-Control", scope+", max-age=31536000, immutable")
+	} else {
+		// Revision of branch may change, clients revalidate with ETag.
+		h.Set("Cache-Control", scope+", no-cache")
+	}
+}
+
+// etagMatches returns true if given If-None-Match header value matches given ETag,
+// weak comparison is used as RFC 7232 requires.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified responds 304 and returns true if request is GET or HEAD and
+// client already has the archive with given validators.
+func notModified(w http.ResponseWriter, req *http.Request, v *ArchiveValidators) bool {
+	etag := v.etag()
+	if len(etag) == 0 || req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	}
+
+	matched := false
+	if inm := req.Header.Get("If-None-Match"); len(inm) > 0 {
+		matched = etagMatches(inm, etag)
+	} else if !v.ModTime.IsZero() {
+		// If-Modified-Since is only used when If-None-Match is absent.
+		t, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+		matched = err == nil && !v.ModTime.Truncate(time.Second).After(t)
+	}
+	if !matched {
+		return false
+	}
+
+	v.setHeaders(w.Header())
+	w.WriteHeader(304)
+	return true
+}
+
+// serveObject serves object of given key in given storage as file with given name,
+// see Context.ServeObject.
+func serveObject(w http.ResponseWriter, req *http.Request, s storage.Storage, key, name string, v *ArchiveValidators) error {
+	if storage.Redirect {
+		url, err := s.SignedURL(key, storage.URLExpires)
+		if err == nil {
+			http.Redirect(w, req, url, 302)
+			return nil
+		} else if err != storage.ErrNotSupported {
+			return err
+		}
+	}
+
+	rc, info, err := s.Open(key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	h := w.Header()
+	h.Set("Content-Description", "File Transfer")
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Content-Disposition", "attachment; filename="+name)
+
+	modtime := info.ModTime
+	if len(v.etag()) > 0 {
+		v.setHeaders(h)
+		if !v.ModTime.IsZero() {
+			modtime = v.ModTime
+		}
+	}
+
+	// Seekable objects support range and conditional requests,
+	// which are validated against ETag set above.
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, req, name, modtime, rs)
+		return nil
+	}
+
+	if info.Size >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	if !modtime.IsZero() {
+		h.Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	}
+	h.Set("Accept-Ranges", "none")
+	w.WriteHeader(200)
+	if req.Method == "HEAD" {
+		return nil
+	}
+	if _, err = io.Copy(w, rc); err != nil {
+		log.Warn("Fail to stream object(%s): %v", key, err)
+	}
+	return nil
+}
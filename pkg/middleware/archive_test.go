@@ -0,0 +1,174 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gpmgo/switch/pkg/storage"
+)
+
+// testArchive saves an archive in a temporary local storage, and returns the
+// storage, content and validators of it.
+func testArchive(t *testing.T) (storage.Storage, []byte, *ArchiveValidators, func()) {
+	dir, err := ioutil.TempDir("", "switch-middleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	s := storage.NewLocal(dir)
+	if err = s.Put("archive.zip", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(data)
+	v := &ArchiveValidators{
+		Sha256:    hex.EncodeToString(sum[:]),
+		ModTime:   time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC),
+		Immutable: true,
+	}
+	return s, data, v, func() { os.RemoveAll(dir) }
+}
+
+// serve serves the archive for given request the way download route does.
+func serve(t *testing.T, s storage.Storage, v *ArchiveValidators, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	if notModified(w, req, v) {
+		return w
+	}
+	if err := serveObject(w, req, s, "archive.zip", "repo.zip", v); err != nil {
+		t.Fatalf("serveObject: %v", err)
+	}
+	return w
+}
+
+func TestServeArchive(t *testing.T) {
+	s, data, v, clean := testArchive(t)
+	defer clean()
+	etag := `"` + v.Sha256 + `"`
+	sum := sha256.Sum256(data)
+
+	t.Run("200", func(t *testing.T) {
+		w := serve(t, s, v, httptest.NewRequest("GET", "/api/v1/download", nil))
+		if w.Code != 200 || !bytes.Equal(w.Body.Bytes(), data) {
+			t.Fatalf("status = %d, body length = %d", w.Code, w.Body.Len())
+		}
+		for k, want := range map[string]string{
+			"ETag":          etag,
+			"Digest":        "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:]),
+			"Last-Modified": "Mon, 01 Jun 2015 12:00:00 GMT",
+			"Cache-Control": "public, max-age=31536000, immutable",
+			"Accept-Ranges": "bytes",
+		} {
+			if got := w.Header().Get(k); got != want {
+				t.Errorf("%s = %q, want %q", k, got, want)
+			}
+		}
+	})
+
+	t.Run("304", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/download", nil)
+		req.Header.Set("If-None-Match", `"other", `+etag)
+		w := serve(t, s, v, req)
+		if w.Code != 304 || w.Body.Len() != 0 {
+			t.Fatalf("status = %d, body length = %d, want 304 without body", w.Code, w.Body.Len())
+		}
+		if w.Header().Get("ETag") != etag {
+			t.Errorf("ETag = %q, want %q", w.Header().Get("ETag"), etag)
+		}
+	})
+
+	t.Run("304 by modification time", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/download", nil)
+		req.Header.Set("If-Modified-Since", "Mon, 01 Jun 2015 12:00:00 GMT")
+		if w := serve(t, s, v, req); w.Code != 304 {
+			t.Fatalf("status = %d, want 304", w.Code)
+		}
+	})
+
+	t.Run("200 with other ETag", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/download", nil)
+		req.Header.Set("If-None-Match", `"other"`)
+		if w := serve(t, s, v, req); w.Code != 200 {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+	})
+
+	t.Run("POST is not conditional", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/download", nil)
+		req.Header.Set("If-None-Match", etag)
+		if notModified(httptest.NewRecorder(), req, v) {
+			t.Fatal("POST request is responded with 304")
+		}
+	})
+
+	t.Run("206", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/download", nil)
+		req.Header.Set("Range", "bytes=100-199")
+		w := serve(t, s, v, req)
+		if w.Code != 206 {
+			t.Fatalf("status = %d, want 206", w.Code)
+		}
+		if !bytes.Equal(w.Body.Bytes(), data[100:200]) {
+			t.Errorf("body = %q, want %q", w.Body.Bytes(), data[100:200])
+		}
+		if cr := w.Header().Get("Content-Range"); cr != "bytes 100-199/1000" {
+			t.Errorf("Content-Range = %q", cr)
+		}
+	})
+
+	t.Run("416", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/download", nil)
+		req.Header.Set("Range", "bytes=5000-")
+		if w := serve(t, s, v, req); w.Code != 416 {
+			t.Fatalf("status = %d, want 416", w.Code)
+		}
+	})
+
+	t.Run("If-Range matched", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/download", nil)
+		req.Header.Set("Range", "bytes=100-199")
+		req.Header.Set("If-Range", etag)
+		if w := serve(t, s, v, req); w.Code != 206 {
+			t.Fatalf("status = %d, want 206", w.Code)
+		}
+	})
+
+	t.Run("If-Range mismatched", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/download", nil)
+		req.Header.Set("Range", "bytes=100-199")
+		req.Header.Set("If-Range", `"other"`)
+		w := serve(t, s, v, req)
+		if w.Code != 200 || !bytes.Equal(w.Body.Bytes(), data) {
+			t.Fatalf("status = %d, body length = %d, want whole archive", w.Code, w.Body.Len())
+		}
+	})
+
+	t.Run("without validators", func(t *testing.T) {
+		w := serve(t, s, nil, httptest.NewRequest("POST", "/download", nil))
+		if w.Code != 200 || len(w.Header().Get("ETag")) > 0 || len(w.Header().Get("Cache-Control")) > 0 {
+			t.Fatalf("status = %d, header = %v", w.Code, w.Header())
+		}
+	})
+}
@@ -15,15 +15,20 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-macaron/session"
 	"gopkg.in/macaron.v1"
 
+	"github.com/gpmgo/switch/pkg/archive"
 	"github.com/gpmgo/switch/pkg/base"
 	"github.com/gpmgo/switch/pkg/log"
 	"github.com/gpmgo/switch/pkg/setting"
+	"github.com/gpmgo/switch/pkg/storage"
 )
 
 // Context represents context of a request.
@@ -75,6 +80,85 @@ func (ctx *Context) RenderWithErr(msg string, tpl base.TplName, form interface{}
 	ctx.HTML(200, tpl)
 }
 
+// RetryLater sets Retry-After header and returns true if given error is caused by
+// exhausted rate limit of upstream, which should be responded with 503.
+func (ctx *Context) RetryLater(err error) bool {
+	wait, ok := archive.RetryAfter(err)
+	if !ok {
+		return false
+	}
+	ctx.Resp.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+	return true
+}
+
+// presentsToken returns true if client presents one of given tokens, as
+// "Authorization: token <token>", password of basic authentication or "token" query.
+func (ctx *Context) presentsToken(tokens ...string) bool {
+	token := ctx.Query("token")
+	if auth := ctx.Req.Header.Get("Authorization"); len(auth) > 0 {
+		if _, password, ok := ctx.Req.BasicAuth(); ok {
+			token = password
+		} else if fields := strings.Fields(auth); len(fields) == 2 {
+			token = fields[1]
+		}
+	}
+	if len(token) == 0 {
+		return false
+	}
+	for _, t := range tokens {
+		if len(t) > 0 && subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin returns true if client is signed in as admin or presents admin access token.
+func (ctx *Context) IsAdmin() bool {
+	if len(setting.AccessToken) == 0 {
+		return false
+	}
+	return ctx.GetCookie("access_token") == setting.AccessToken || ctx.presentsToken(setting.AccessToken)
+}
+
+// IsPrivateAuthorized returns true if client is allowed to access private packages,
+// which is either signed in as admin or presents one of private access tokens.
+func (ctx *Context) IsPrivateAuthorized() bool {
+	if len(setting.AccessToken) > 0 && ctx.GetCookie("access_token") == setting.AccessToken {
+		return true
+	}
+	return ctx.presentsToken(setting.PrivateAccessTokens...)
+}
+
+// IsRetentionAuthorized returns true if client is allowed to pin and unpin revisions,
+// which is either admin or presents one of retention access tokens.
+func (ctx *Context) IsRetentionAuthorized() bool {
+	return ctx.IsAdmin() || ctx.presentsToken(setting.RetentionAccessTokens...)
+}
+
+// NotModified responds 304 and returns true if request is GET or HEAD and client
+// already has the archive with given validators, which must not be served or
+// counted again.
+func (ctx *Context) NotModified(v *ArchiveValidators) bool {
+	return notModified(ctx.Resp, ctx.Req.Request, v)
+}
+
+// IsResumedDownload returns true if client requests a range that does not start
+// from beginning, which continues a download that has been counted.
+func (ctx *Context) IsResumedDownload() bool {
+	r := ctx.Req.Header.Get("Range")
+	return len(r) > 0 && !strings.HasPrefix(r, "bytes=0-")
+}
+
+// ServeObject serves object of given key in given storage as file with given name.
+// It redirects to signed URL when the storage supports it and redirect is enabled,
+// otherwise the object is streamed. Error is returned before anything is written.
+// Validators of archive are optional, they are not sent with redirects since
+// signed URLs expire.
+func (ctx *Context) ServeObject(s storage.Storage, key, name string, v *ArchiveValidators) error {
+	return serveObject(ctx.Resp, ctx.Req.Request, s, key, name, v)
+}
+
 // Handle handles and logs error by given status.
 func (ctx *Context) Handle(status int, title string, err error) {
 	if err != nil {
@@ -0,0 +1,387 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package module implements helpers to serve Go module proxy protocol from cached archives.
+package module
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gpmgo/switch/pkg/semver"
+)
+
+var (
+	ErrInvalidEscape = errors.New("invalid escaped path")
+
+	semverPattern = regexp.MustCompile(`^v(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(-[0-9A-Za-z\-.]+)?(\+incompatible)?$`)
+	majorPattern  = regexp.MustCompile(`^v([2-9]|[1-9][0-9]+)$`)
+	pseudoPattern = regexp.MustCompile(`^v[0-9]+\.(0\.0-|[0-9]+\.[0-9]+-([^+]*\.)?0\.)[0-9]{14}-[A-Za-z0-9]+(\+[0-9A-Za-z\-]+(\.[0-9A-Za-z\-]+)*)?$`)
+)
+
+// unescape decodes "!x" sequences back to upper case letters.
+func unescape(escaped string) (string, error) {
+	buf := make([]byte, 0, len(escaped))
+	bang := false
+	for i := 0; i < len(escaped); i++ {
+		c := escaped[i]
+		switch {
+		case bang:
+			if c < 'a' || c > 'z' {
+				return "", ErrInvalidEscape
+			}
+			buf = append(buf, c-'a'+'A')
+			bang = false
+		case c == '!':
+			bang = true
+		case c >= 'A' && c <= 'Z':
+			return "", ErrInvalidEscape
+		default:
+			buf = append(buf, c)
+		}
+	}
+	if bang {
+		return "", ErrInvalidEscape
+	}
+	return string(buf), nil
+}
+
+// UnescapePath returns module path of given escaped form.
+func UnescapePath(escaped string) (string, error) {
+	return unescape(escaped)
+}
+
+// UnescapeVersion returns version of given escaped form.
+func UnescapeVersion(escaped string) (string, error) {
+	return unescape(escaped)
+}
+
+// IsSemver returns true if given version is a canonical semantic version.
+func IsSemver(v string) bool {
+	return semverPattern.MatchString(v)
+}
+
+// IsPseudoVersion returns true if given version is a pseudo-version.
+func IsPseudoVersion(v string) bool {
+	return strings.Count(v, "-") >= 2 && pseudoPattern.MatchString(v)
+}
+
+// PseudoVersionRev returns the revision identifier of given pseudo-version.
+func PseudoVersionRev(v string) string {
+	v = strings.Split(v, "+")[0]
+	return v[strings.LastIndex(v, "-")+1:]
+}
+
+// PseudoVersion returns pseudo-version of given commit time and revision, which
+// follows given nearest preceding semantic version tag. It is a v0.0.0 one when
+// tag is empty.
+func PseudoVersion(tag string, t time.Time, rev string) string {
+	if len(tag) == 0 {
+		return PseudoVersionMajor("", t, rev)
+	}
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	suffix := t.UTC().Format("20060102150405") + "-" + rev
+
+	v, err := semver.Parse(tag)
+	switch {
+	case err != nil:
+		return "v0.0.0-" + suffix
+	case len(v.Pre) > 0:
+		// e.g. v1.2.3-pre.0.20150301120000-abcdef123456
+		return "v" + v.String() + ".0." + suffix
+	}
+	// e.g. v1.2.4-0.20150301120000-abcdef123456
+	return fmt.Sprintf("v%d.%d.%d-0.%s", v.Major, v.Minor, v.Patch+1, suffix)
+}
+
+// PseudoVersionMajor returns pseudo-version of given commit time and revision
+// without preceding tag for module with given major version suffix, e.g. a
+// v2.0.0 one for "v2", and a v0.0.0 one when major is empty.
+func PseudoVersionMajor(major string, t time.Time, rev string) string {
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	if len(major) == 0 {
+		major = "v0"
+	}
+	return major + ".0.0-" + t.UTC().Format("20060102150405") + "-" + rev
+}
+
+// SplitPathMajor splits given module path into path prefix and major version
+// suffix, e.g. "github.com/user/repo" and "v2" for "github.com/user/repo/v2".
+// Major is empty if the path has no major version suffix.
+func SplitPathMajor(modPath string) (prefix, major string) {
+	i := strings.LastIndex(modPath, "/")
+	if majorPattern.MatchString(modPath[i+1:]) {
+		if i == -1 {
+			return "", modPath
+		}
+		return modPath[:i], modPath[i+1:]
+	}
+	return modPath, ""
+}
+
+// TagVersion returns version of given tag for module with given tag prefix and
+// major version suffix, e.g. "v2.1.0" of tag "tools/v2.1.0" for prefix "tools/"
+// and major "v2". It returns false if the tag is not a canonical semantic version
+// of the module, modules without major version suffix only have v0 and v1 versions.
+func TagVersion(tag, prefix, major string) (string, bool) {
+	if !strings.HasPrefix(tag, prefix) {
+		return "", false
+	}
+	ver := strings.TrimPrefix(tag, prefix)
+	v, err := semver.Parse(ver)
+	if err != nil || "v"+v.String() != ver {
+		return "", false
+	}
+	if len(major) == 0 {
+		return ver, v.Major <= 1
+	}
+	return ver, fmt.Sprintf("v%d", v.Major) == major
+}
+
+// IsCanonicalTag returns true if given tag is a canonical semantic version
+// that a module at repository root without major version suffix can have.
+func IsCanonicalTag(tag string) bool {
+	_, ok := TagVersion(tag, "", "")
+	return ok
+}
+
+// stripRoot returns name without the top-level directory that
+// upstream services put in archives (e.g. "repo-sha/").
+func stripRoot(name string) string {
+	i := strings.Index(name, "/")
+	if i == -1 {
+		return ""
+	}
+	return name[i+1:]
+}
+
+// CommitTime returns modification time of top-level directory in given archive,
+// which is the commit time for archives generated by upstream services.
+func CommitTime(archivePath string) (time.Time, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer r.Close()
+
+	if len(r.File) == 0 {
+		return time.Time{}, fmt.Errorf("empty archive: %s", archivePath)
+	}
+	return r.File[0].Modified, nil
+}
+
+// readFile returns content of file with given name in archive, it returns nil if not found.
+func readFile(r *zip.ReadCloser, name string) ([]byte, error) {
+	for _, f := range r.File {
+		if stripRoot(f.Name) != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+	return nil, nil
+}
+
+// modulePath returns module path declared in given content of go.mod.
+func modulePath(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "//"); i > -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], "\"`")
+		}
+	}
+	return ""
+}
+
+// FindModuleDir returns the first one of given candidate directories in given
+// archive that has a go.mod declaring given module path. Repository root "" is
+// accepted without go.mod for repositories not converted to modules yet, unless
+// module path has major version suffix.
+func FindModuleDir(archivePath, modPath string, dirs ...string) (string, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	_, major := SplitPathMajor(modPath)
+	for _, dir := range dirs {
+		data, err := readFile(r, path.Join(dir, "go.mod"))
+		if err != nil {
+			return "", err
+		} else if data == nil {
+			if len(dir) == 0 && len(major) == 0 {
+				return dir, nil
+			}
+			continue
+		}
+		if modulePath(data) == modPath {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find module %s in repository", modPath)
+}
+
+// GoMod returns content of go.mod in given module directory of given archive,
+// or synthesizes one when the module has none.
+func GoMod(archivePath, dir, modPath string) ([]byte, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := readFile(r, path.Join(dir, "go.mod"))
+	if err != nil {
+		return nil, err
+	} else if data == nil {
+		data = []byte(fmt.Sprintf("module %s\n", modPath))
+	}
+	return data, nil
+}
+
+// isVendoredPackage returns true if given file belongs to a vendored package.
+func isVendoredPackage(name string) bool {
+	var i int
+	if strings.HasPrefix(name, "vendor/") {
+		i += len("vendor/")
+	} else if j := strings.Index(name, "/vendor/"); j >= 0 {
+		i += j + len("/vendor/")
+	} else {
+		return false
+	}
+	return strings.Contains(name[i:], "/")
+}
+
+// moduleFile returns name of file relative to given module directory,
+// it returns empty string if the file is not in the directory.
+func moduleFile(name, dir string) string {
+	name = stripRoot(name)
+	if len(dir) == 0 {
+		return name
+	} else if !strings.HasPrefix(name, dir+"/") {
+		return ""
+	}
+	return strings.TrimPrefix(name, dir+"/")
+}
+
+// Repack converts subtree of given module directory in archive at given path to module
+// zip layout and saves to dst. Files are prefixed with "modPath@version/", files of
+// nested modules and vendored packages are dropped, and go.mod is synthesized when
+// the module has none.
+func Repack(archivePath, dst, dir, modPath, version string) (err error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// Find out directories of nested modules.
+	nested := make([]string, 0, 5)
+	hasGoMod := false
+	for _, f := range r.File {
+		name := moduleFile(f.Name, dir)
+		if len(name) == 0 {
+			continue
+		} else if name == "go.mod" {
+			hasGoMod = true
+		} else if path.Base(name) == "go.mod" {
+			nested = append(nested, path.Dir(name)+"/")
+		}
+	}
+
+	if err = os.MkdirAll(path.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	// Concurrent repacks of same version write their own temporary files,
+	// and the complete one replaces destination atomically.
+	fw, err := ioutil.TempFile(filepath.Dir(dst), filepath.Base(dst)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := fw.Name()
+	defer func() {
+		fw.Close()
+		if err != nil {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	prefix := modPath + "@" + version + "/"
+	zw := zip.NewWriter(fw)
+FILES:
+	for _, f := range r.File {
+		name := moduleFile(f.Name, dir)
+		if len(name) == 0 || !f.Mode().IsRegular() || isVendoredPackage(name) {
+			continue
+		}
+		for _, dir := range nested {
+			if strings.HasPrefix(name, dir) {
+				continue FILES
+			}
+		}
+
+		w, err := zw.Create(prefix + name)
+		if err != nil {
+			return err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !hasGoMod {
+		w, err := zw.Create(prefix + "go.mod")
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "module %s\n", modPath); err != nil {
+			return err
+		}
+	}
+
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	if err = fw.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
@@ -0,0 +1,326 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package module
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testRev = "abcdef1234567890abcdef1234567890abcdef12"
+
+var testTime = time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestPseudoVersion(t *testing.T) {
+	cases := []struct {
+		tag  string
+		t    time.Time
+		rev  string
+		want string
+	}{
+		{"", testTime, testRev, "v0.0.0-20150301120000-abcdef123456"},
+		{"master", testTime, testRev, "v0.0.0-20150301120000-abcdef123456"},
+		{"v1.2.3", testTime, testRev, "v1.2.4-0.20150301120000-abcdef123456"},
+		{"v0.1.0", testTime, testRev, "v0.1.1-0.20150301120000-abcdef123456"},
+		{"v1.2.3-pre", testTime, testRev, "v1.2.3-pre.0.20150301120000-abcdef123456"},
+		{"v2.0.0-rc.1", testTime, testRev, "v2.0.0-rc.1.0.20150301120000-abcdef123456"},
+		{"v1.2.3", testTime.In(time.FixedZone("UTC+8", 8*3600)), testRev, "v1.2.4-0.20150301120000-abcdef123456"},
+		{"v1.2.3", testTime, "abc123", "v1.2.4-0.20150301120000-abc123"},
+	}
+	for _, c := range cases {
+		v := PseudoVersion(c.tag, c.t, c.rev)
+		if v != c.want {
+			t.Errorf("PseudoVersion(%q) = %q, want %q", c.tag, v, c.want)
+			continue
+		}
+		checkPseudoVersion(t, v, c.rev)
+	}
+}
+
+func TestPseudoVersionMajor(t *testing.T) {
+	cases := []struct {
+		major string
+		want  string
+	}{
+		{"", "v0.0.0-20150301120000-abcdef123456"},
+		{"v2", "v2.0.0-20150301120000-abcdef123456"},
+		{"v10", "v10.0.0-20150301120000-abcdef123456"},
+	}
+	for _, c := range cases {
+		v := PseudoVersionMajor(c.major, testTime, testRev)
+		if v != c.want {
+			t.Errorf("PseudoVersionMajor(%q) = %q, want %q", c.major, v, c.want)
+			continue
+		}
+		checkPseudoVersion(t, v, testRev)
+	}
+}
+
+// checkPseudoVersion checks given pseudo-version is recognized and refers to given revision.
+func checkPseudoVersion(t *testing.T, v, rev string) {
+	if !IsPseudoVersion(v) {
+		t.Errorf("IsPseudoVersion(%q) = false", v)
+	}
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	if got := PseudoVersionRev(v); got != rev {
+		t.Errorf("PseudoVersionRev(%q) = %q, want %q", v, got, rev)
+	}
+	if got := PseudoVersionRev(v + "+incompatible"); got != rev {
+		t.Errorf("PseudoVersionRev(%q) = %q, want %q", v+"+incompatible", got, rev)
+	}
+}
+
+func TestIsPseudoVersion(t *testing.T) {
+	cases := []struct {
+		v    string
+		want bool
+	}{
+		{"v2.0.1-0.20150301120000-abcdef123456+incompatible", true},
+		{"v1.2.3", false},
+		{"v1.2.3-pre", false},
+		{"v1.2.3-pre.0.20150301", false},
+		{"v1.2.4-0.2015030112-abcdef123456", false},
+		{"v1.2.4-1.20150301120000-abcdef123456", false},
+		{"v0.0.0-20150301120000", false},
+	}
+	for _, c := range cases {
+		if got := IsPseudoVersion(c.v); got != c.want {
+			t.Errorf("IsPseudoVersion(%q) = %v, want %v", c.v, got, c.want)
+		}
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	cases := []struct {
+		escaped string
+		want    string
+	}{
+		{"github.com/gpmgo/switch", "github.com/gpmgo/switch"},
+		{"github.com/!azure/azure-sdk", "github.com/Azure/azure-sdk"},
+		{"github.com/!burnt!sushi/toml", "github.com/BurntSushi/toml"},
+		{"!a!b!c", "ABC"},
+		{"v1.0.0-!r!c1", "v1.0.0-RC1"},
+	}
+	for _, c := range cases {
+		got, err := unescape(c.escaped)
+		if err != nil {
+			t.Errorf("unescape(%q): %v", c.escaped, err)
+		} else if got != c.want {
+			t.Errorf("unescape(%q) = %q, want %q", c.escaped, got, c.want)
+		}
+	}
+
+	for _, escaped := range []string{"github.com/Azure/azure-sdk", "!", "repo!", "!!a", "!1", "!-"} {
+		if got, err := unescape(escaped); err != ErrInvalidEscape {
+			t.Errorf("unescape(%q) = %q, %v, want %v", escaped, got, err, ErrInvalidEscape)
+		}
+	}
+}
+
+// testArchive saves a zip archive of given files under a top-level directory
+// like upstream services do, and returns its path.
+func testArchive(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "switch-module")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(dir, "archive.zip")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	if _, err = zw.Create("repo-" + testRev[:7] + "/"); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		w, err := zw.Create("repo-" + testRev[:7] + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archivePath, func() { os.RemoveAll(dir) }
+}
+
+var testRepoFiles = map[string]string{
+	"go.mod":                            "module example.com/repo // root\n",
+	"main.go":                           "package main\n",
+	"sub/sub.go":                        "package sub\n",
+	"vendor/modules.txt":                "# example.com/dep v1.0.0\n",
+	"vendor/example.com/dep/dep.go":     "package dep\n",
+	"sub/vendor/example.com/dep/dep.go": "package dep\n",
+	"nested/go.mod":                     "module example.com/repo/nested\n",
+	"nested/nested.go":                  "package nested\n",
+	"nested/deep/deep.go":               "package deep\n",
+	"v2/go.mod":                         "module \"example.com/repo/v2\"\n",
+	"v2/v2.go":                          "package repo\n",
+	"tools/tools.go":                    "package tools\n",
+}
+
+func TestFindModuleDir(t *testing.T) {
+	archivePath, cleanup := testArchive(t, testRepoFiles)
+	defer cleanup()
+	legacyPath, cleanupLegacy := testArchive(t, map[string]string{"main.go": "package main\n"})
+	defer cleanupLegacy()
+
+	cases := []struct {
+		archivePath string
+		modPath     string
+		dirs        []string
+		want        string
+	}{
+		{archivePath, "example.com/repo", []string{""}, ""},
+		{archivePath, "example.com/repo/v2", []string{"", "v2"}, "v2"},
+		{archivePath, "example.com/repo/nested", []string{"nested"}, "nested"},
+		{legacyPath, "example.com/legacy", []string{""}, ""},
+	}
+	for _, c := range cases {
+		dir, err := FindModuleDir(c.archivePath, c.modPath, c.dirs...)
+		if err != nil {
+			t.Errorf("FindModuleDir(%q): %v", c.modPath, err)
+		} else if dir != c.want {
+			t.Errorf("FindModuleDir(%q) = %q, want %q", c.modPath, dir, c.want)
+		}
+	}
+
+	// Modules must be declared by go.mod unless it is at root of repository
+	// without go.mod and has no major version suffix.
+	for _, c := range []struct {
+		archivePath string
+		modPath     string
+		dirs        []string
+	}{
+		{archivePath, "example.com/other", []string{""}},
+		{archivePath, "example.com/repo/tools", []string{"tools"}},
+		{legacyPath, "example.com/legacy/v2", []string{"", "v2"}},
+	} {
+		if dir, err := FindModuleDir(c.archivePath, c.modPath, c.dirs...); err == nil {
+			t.Errorf("FindModuleDir(%q) = %q, want error", c.modPath, dir)
+		}
+	}
+}
+
+// readArchive returns contents of files in given zip archive.
+func readArchive(t *testing.T, archivePath string) map[string]string {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	files := make(map[string]string, len(r.File))
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(data)
+	}
+	return files
+}
+
+func TestRepack(t *testing.T) {
+	archivePath, cleanup := testArchive(t, testRepoFiles)
+	defer cleanup()
+
+	cases := []struct {
+		name    string
+		dir     string
+		modPath string
+		want    map[string]string
+	}{
+		{
+			// Nested modules and vendored packages are dropped.
+			"root", "", "example.com/repo",
+			map[string]string{
+				"go.mod":             "module example.com/repo // root\n",
+				"main.go":            "package main\n",
+				"sub/sub.go":         "package sub\n",
+				"vendor/modules.txt": "# example.com/dep v1.0.0\n",
+				"tools/tools.go":     "package tools\n",
+			},
+		},
+		{
+			"major subdirectory", "v2", "example.com/repo/v2",
+			map[string]string{
+				"go.mod": "module \"example.com/repo/v2\"\n",
+				"v2.go":  "package repo\n",
+			},
+		},
+		{
+			"nested", "nested", "example.com/repo/nested",
+			map[string]string{
+				"go.mod":       "module example.com/repo/nested\n",
+				"nested.go":    "package nested\n",
+				"deep/deep.go": "package deep\n",
+			},
+		},
+		{
+			// go.mod is synthesized for module without one.
+			"without go.mod", "tools", "example.com/repo/tools",
+			map[string]string{
+				"go.mod":   "module example.com/repo/tools\n",
+				"tools.go": "package tools\n",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir(filepath.Dir(archivePath), "cache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst := filepath.Join(dir, "v1.0.0.zip")
+			if err := Repack(archivePath, dst, c.dir, c.modPath, "v1.0.0"); err != nil {
+				t.Fatalf("Repack: %v", err)
+			}
+
+			want := make(map[string]string, len(c.want))
+			for name, content := range c.want {
+				want[c.modPath+"@v1.0.0/"+name] = content
+			}
+			if got := readArchive(t, dst); !reflect.DeepEqual(got, want) {
+				t.Errorf("files = %v, want %v", got, want)
+			}
+
+			// No temporary file is left.
+			names, err := filepath.Glob(filepath.Join(dir, "*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(names) != 1 || names[0] != dst {
+				t.Errorf("files in destination directory = %v, want only %s", names, dst)
+			}
+		})
+	}
+}
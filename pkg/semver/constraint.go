@@ -0,0 +1,197 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package semver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	LATEST        = "latest"        // Highest version including pre-releases.
+	LATEST_STABLE = "latest-stable" // Highest version excluding pre-releases.
+)
+
+// comparator represents a single comparison like ">=1.2".
+type comparator struct {
+	op string
+	v  *Version
+}
+
+func (c *comparator) check(v *Version) bool {
+	base := c.v
+	switch c.op {
+	case "^":
+		var upper *Version
+		switch {
+		case base.Major > 0 || base.parts == 1:
+			upper = base.bump(1)
+		case base.Minor > 0 || base.parts == 2:
+			upper = base.bump(2)
+		default:
+			upper = base.bump(3)
+		}
+		return v.Compare(base) >= 0 && v.Compare(upper) < 0
+	case "~":
+		upper := base.bump(2)
+		if base.parts == 1 {
+			upper = base.bump(1)
+		}
+		return v.Compare(base) >= 0 && v.Compare(upper) < 0
+	case ">=":
+		return v.Compare(base) >= 0
+	case "<":
+		return v.Compare(base) < 0
+	case ">":
+		if base.IsFull() {
+			return v.Compare(base) > 0
+		}
+		return v.Compare(base.bump(base.parts)) >= 0
+	case "<=":
+		if base.IsFull() {
+			return v.Compare(base) <= 0
+		}
+		return v.Compare(base.bump(base.parts)) < 0
+	case "!=":
+		return !(&comparator{"=", base}).check(v)
+	}
+
+	// Equal, partial version matches all versions it stands for.
+	if base.IsFull() {
+		return v.Compare(base) == 0
+	}
+	return v.Compare(base) >= 0 && v.Compare(base.bump(base.parts)) < 0
+}
+
+// Constraint represents a version constraint, which is alternatives separated
+// by "||", and each alternative is comparators separated by spaces or commas
+// that all must be satisfied, e.g. ">=1.2 <2 || ^3.1".
+type Constraint struct {
+	alternatives [][]*comparator
+}
+
+var operators = []string{">=", "<=", "!=", "^", "~", ">", "<", "="}
+
+// IsQuery returns true if given revision is a version query rather than a reference name.
+func IsQuery(rev string) bool {
+	if rev == LATEST || rev == LATEST_STABLE || strings.Contains(rev, "||") {
+		return true
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(rev, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseConstraint parses a version constraint.
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{}
+	for _, alt := range strings.Split(s, "||") {
+		// Allow space between operator and version, e.g. ">= 1.2".
+		fields := strings.Fields(strings.Replace(alt, ",", " ", -1))
+		comps := make([]*comparator, 0, len(fields))
+		for i := 0; i < len(fields); i++ {
+			f := fields[i]
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(f, o) {
+					op = o
+					break
+				}
+			}
+			f = strings.TrimPrefix(f, op)
+			if len(f) == 0 && i+1 < len(fields) {
+				i++
+				f = fields[i]
+			}
+
+			v, err := Parse(f)
+			if err != nil {
+				return nil, fmt.Errorf("invalid constraint %q: %v", s, err)
+			}
+			comps = append(comps, &comparator{op, v})
+		}
+		if len(comps) == 0 {
+			return nil, fmt.Errorf("invalid constraint %q: empty alternative", s)
+		}
+		c.alternatives = append(c.alternatives, comps)
+	}
+	return c, nil
+}
+
+// Check returns true if given version satisfies the constraint. Pre-release
+// versions only satisfy an alternative which mentions a pre-release.
+func (c *Constraint) Check(v *Version) bool {
+	for _, comps := range c.alternatives {
+		ok := true
+		allowPre := false
+		for _, comp := range comps {
+			if !comp.check(v) {
+				ok = false
+				break
+			}
+			if len(comp.v.Pre) > 0 {
+				allowPre = true
+			}
+		}
+		if ok && (len(v.Pre) == 0 || allowPre) {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns the name of the highest version that satisfies given query
+// from given names, names are not valid versions are ignored.
+func Select(names []string, query string) (string, error) {
+	var check func(*Version) bool
+	switch query {
+	case LATEST:
+		check = func(*Version) bool { return true }
+	case LATEST_STABLE:
+		check = func(v *Version) bool { return len(v.Pre) == 0 }
+	default:
+		c, err := ParseConstraint(query)
+		if err != nil {
+			return "", err
+		}
+		check = c.Check
+	}
+
+	// Sort names to get same result among equal versions, e.g. "v1.0.0" and "1.0.0".
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+
+	var best *Version
+	bestName := ""
+	for _, name := range sorted {
+		v, err := Parse(name)
+		if err != nil || !check(v) {
+			continue
+		}
+		if best == nil || v.Compare(best) > 0 {
+			best = v
+			bestName = name
+		}
+	}
+	if best == nil {
+		return "", fmt.Errorf("no version matches %q", query)
+	}
+	return bestName, nil
+}
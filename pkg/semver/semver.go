@@ -0,0 +1,174 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package semver implements semantic versions and version constraints,
+// for selecting the best matching version tag of a repository.
+package semver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version represents a semantic version.
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 string // Pre-release identifiers, without leading "-".
+
+	parts int // Number of numeric parts given, 1 to 3.
+}
+
+// Parse parses a version with optional "v" prefix. Minor and patch parts can be
+// omitted, and build metadata is ignored.
+func Parse(s string) (*Version, error) {
+	v := &Version{}
+	str := strings.TrimPrefix(s, "v")
+	if i := strings.Index(str, "+"); i > -1 {
+		str = str[:i]
+	}
+	if i := strings.Index(str, "-"); i > -1 {
+		v.Pre = str[i+1:]
+		str = str[:i]
+		if len(v.Pre) == 0 {
+			return nil, fmt.Errorf("invalid version: %s", s)
+		}
+	}
+
+	fields := strings.Split(str, ".")
+	if len(fields) > 3 {
+		return nil, fmt.Errorf("invalid version: %s", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, f := range fields {
+		if len(f) == 0 || (len(f) > 1 && f[0] == '0') {
+			return nil, fmt.Errorf("invalid version: %s", s)
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version: %s", s)
+		}
+		*nums[i] = n
+	}
+	v.parts = len(fields)
+	return v, nil
+}
+
+// IsFull returns true if all of major, minor and patch parts are given.
+func (v *Version) IsFull() bool {
+	return v.parts == 3
+}
+
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Pre) > 0 {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// comparePre compares pre-release identifiers by semantic versioning rules,
+// a version without pre-release has higher precedence.
+func comparePre(a, b string) int {
+	if a == b {
+		return 0
+	} else if len(a) == 0 {
+		return 1
+	} else if len(b) == 0 {
+		return -1
+	}
+
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			return compareInt(an, bn)
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		case as[i] < bs[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return compareInt(len(as), len(bs))
+}
+
+// Compare returns -1, 0 or 1 if v is lower than, equal to or higher than o.
+func (v *Version) Compare(o *Version) int {
+	if c := compareInt(v.Major, o.Major); c != 0 {
+		return c
+	} else if c = compareInt(v.Minor, o.Minor); c != 0 {
+		return c
+	} else if c = compareInt(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	return comparePre(v.Pre, o.Pre)
+}
+
+// bump returns the lowest version higher than all versions that v stands for
+// by given number of parts, e.g. 1.3.0 for 1.2.
+func (v *Version) bump(parts int) *Version {
+	switch parts {
+	case 1:
+		return &Version{Major: v.Major + 1, parts: 3}
+	case 2:
+		return &Version{Major: v.Major, Minor: v.Minor + 1, parts: 3}
+	}
+	return &Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1, parts: 3}
+}
+
+// Sort sorts given names by version from highest to lowest,
+// names are not valid versions are put last in alphabetical order.
+func Sort(names []string) {
+	versions := make(map[string]*Version, len(names))
+	for _, name := range names {
+		if v, err := Parse(name); err == nil {
+			versions[name] = v
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		vi, vj := versions[names[i]], versions[names[j]]
+		switch {
+		case vi != nil && vj != nil:
+			if c := vi.Compare(vj); c != 0 {
+				return c > 0
+			}
+			return names[i] < names[j]
+		case vi != nil:
+			return true
+		case vj != nil:
+			return false
+		}
+		return names[i] < names[j]
+	})
+}
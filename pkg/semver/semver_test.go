@@ -0,0 +1,243 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package semver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		s    string
+		want string
+		full bool
+	}{
+		{"1.2.3", "1.2.3", true},
+		{"v1.2.3", "1.2.3", true},
+		{"v1.2.3-rc.1+build.5", "1.2.3-rc.1", true},
+		{"1.2", "1.2.0", false},
+		{"1", "1.0.0", false},
+		{"v0.0.0-beta", "0.0.0-beta", true},
+	}
+	for _, c := range cases {
+		v, err := Parse(c.s)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", c.s, err)
+			continue
+		}
+		if v.String() != c.want || v.IsFull() != c.full {
+			t.Errorf("Parse(%q) = %s, full %v, want %s, full %v", c.s, v, v.IsFull(), c.want, c.full)
+		}
+	}
+
+	for _, s := range []string{"", "v", "1.2.3.4", "01.2.3", "1..3", "1.2.x", "1.2.3-", "-1.2.3", "master"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) succeeds", s)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "v1.2.3", 0},
+		{"1.2.3", "1.10.0", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0.0-alpha", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.2", "1.0.0-alpha.10", -1},
+		{"1.0.0-alpha.beta", "1.0.0-alpha.1", 1},
+		{"1.0.0-rc.1", "1.0.0-beta.11", 1},
+	}
+	for _, c := range cases {
+		a, _ := Parse(c.a)
+		b, _ := Parse(c.b)
+		if got := a.Compare(b); got != c.want {
+			t.Errorf("Compare(%s, %s) = %d, want %d", c.a, c.b, got, c.want)
+		}
+		if got := b.Compare(a); got != -c.want {
+			t.Errorf("Compare(%s, %s) = %d, want %d", c.b, c.a, got, -c.want)
+		}
+	}
+}
+
+func TestConstraintCheck(t *testing.T) {
+	cases := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		// Caret allows changes not modifying the left-most non-zero part.
+		{"^1.2.3", "1.2.3", true},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "1.2.2", false},
+		{"^1.2.3", "2.0.0", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.3", true},
+		{"^0.0.3", "0.0.4", false},
+		{"^1", "1.9.9", true},
+		{"^1", "2.0.0", false},
+		{"^0.2", "0.2.9", true},
+		{"^0.2", "0.3.0", false},
+
+		// Tilde allows patch changes, or minor changes when only major is given.
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.2.2", false},
+		{"~1.2.3", "1.3.0", false},
+		{"~1.2", "1.2.0", true},
+		{"~1.2", "1.3.0", false},
+		{"~1", "1.5.0", true},
+		{"~1", "2.0.0", false},
+
+		{">=1.2.3", "1.2.3", true},
+		{">=1.2.3", "1.2.2", false},
+		{">=1.2", "1.2.0", true},
+		{">=1.2", "1.1.9", false},
+		{"<2.0.0", "1.9.9", true},
+		{"<2.0.0", "2.0.0", false},
+		{"<2", "1.99.0", true},
+		{"<2", "2.0.0", false},
+		{">1.2.3", "1.2.4", true},
+		{">1.2.3", "1.2.3", false},
+		{">1.2", "1.2.9", false},
+		{">1.2", "1.3.0", true},
+		{"<=1.2.3", "1.2.3", true},
+		{"<=1.2.3", "1.2.4", false},
+		{"<=1.2", "1.2.9", true},
+		{"<=1.2", "1.3.0", false},
+		{"!=1.2.3", "1.2.3", false},
+		{"!=1.2.3", "1.2.4", true},
+		{"!=1.2", "1.2.5", false},
+		{"!=1.2", "1.3.0", true},
+
+		// Partial version matches all versions it stands for.
+		{"1.2.3", "1.2.3", true},
+		{"1.2.3", "1.2.4", false},
+		{"=1.2.3", "1.2.3", true},
+		{"1.2", "1.2.7", true},
+		{"1.2", "1.3.0", false},
+		{"1", "1.4.0", true},
+		{"1", "2.0.0", false},
+
+		{">=1.2 <2", "1.5.0", true},
+		{">=1.2, <2", "2.0.0", false},
+		{">= 1.2 < 2", "1.1.0", false},
+		{">=1.2 <2 || ^3.1", "3.5.0", true},
+		{">=1.2 <2 || ^3.1", "3.0.0", false},
+
+		// Pre-releases only match alternatives that mention a pre-release.
+		{"^1.2.3", "1.5.0-beta", false},
+		{"<2", "2.0.0-rc.1", false},
+		{">=1.0.0-beta", "1.0.0-rc.1", true},
+		{">=1.0.0-beta", "1.0.0-alpha", false},
+		{">=1.0.0-beta", "1.0.0", true},
+		{">=1.0.0-0 <2", "1.1.0-beta", true},
+		{"^1.0.0 || >=2.0.0-0", "1.1.0-beta", false},
+		{"^1.0.0 || >=2.0.0-0", "2.1.0-beta", true},
+	}
+	for _, c := range cases {
+		con, err := ParseConstraint(c.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q): %v", c.constraint, err)
+			continue
+		}
+		v, err := Parse(c.version)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.version, err)
+		}
+		if got := con.Check(v); got != c.want {
+			t.Errorf("%q.Check(%s) = %v, want %v", c.constraint, c.version, got, c.want)
+		}
+	}
+}
+
+func TestParseConstraintInvalid(t *testing.T) {
+	for _, s := range []string{"", ">=", ">=x", "^1.2.3.4", "1.2 || ", "~01.2"} {
+		if _, err := ParseConstraint(s); err == nil {
+			t.Errorf("ParseConstraint(%q) succeeds", s)
+		}
+	}
+}
+
+func TestIsQuery(t *testing.T) {
+	cases := []struct {
+		rev  string
+		want bool
+	}{
+		{"latest", true},
+		{"latest-stable", true},
+		{"^1.2", true},
+		{"~1", true},
+		{">=1.0.0", true},
+		{"<2", true},
+		{"!=1.2.3", true},
+		{"v1 || v2", true},
+		{"v1.2.3", false},
+		{"master", false},
+		{"0123456789abcdef", false},
+	}
+	for _, c := range cases {
+		if got := IsQuery(c.rev); got != c.want {
+			t.Errorf("IsQuery(%q) = %v, want %v", c.rev, got, c.want)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	names := []string{"v1.0.0", "v1.2.0", "1.2.0", "v1.3.0-beta", "v2.0.0", "v2.1.0-rc.1", "master", "release-1"}
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{"latest", "v2.1.0-rc.1"},
+		{"latest-stable", "v2.0.0"},
+		{"^1", "1.2.0"}, // Equal versions are selected by name.
+		{"~1.0", "v1.0.0"},
+		{"<1.2", "v1.0.0"},
+		{"!=2", "1.2.0"},
+		{">=1.3.0-beta <2", "v1.3.0-beta"},
+		{"1 || >=2.1.0-0", "v2.1.0-rc.1"},
+	}
+	for _, c := range cases {
+		got, err := Select(names, c.query)
+		if err != nil {
+			t.Errorf("Select(%q): %v", c.query, err)
+		} else if got != c.want {
+			t.Errorf("Select(%q) = %q, want %q", c.query, got, c.want)
+		}
+	}
+
+	for _, query := range []string{"^3", ">=x"} {
+		if got, err := Select(names, query); err == nil {
+			t.Errorf("Select(%q) = %q, want error", query, got)
+		}
+	}
+	if got, err := Select([]string{"master"}, "latest"); err == nil {
+		t.Errorf("Select without versions = %q, want error", got)
+	}
+}
+
+func TestSort(t *testing.T) {
+	names := []string{"beta", "v1.0.0", "1.0.0-alpha", "1.9.0", "v2.0.0", "alpha", "1.10.0", "2.0.0-rc.1", "1.0.0"}
+	Sort(names)
+	want := []string{"v2.0.0", "2.0.0-rc.1", "1.10.0", "1.9.0", "1.0.0", "v1.0.0", "1.0.0-alpha", "alpha", "beta"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Sort = %v, want %v", names, want)
+	}
+}
@@ -17,15 +17,18 @@ package setting
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/Unknwon/com"
-	// "github.com/qiniu/api.v6/conf"
 	"gopkg.in/ini.v1"
 	"gopkg.in/macaron.v1"
 
 	"github.com/gpmgo/switch/pkg/log"
 )
 
+// _DEFAULT_SECRET_KEY is public, so it is not used to encrypt credentials.
+const _DEFAULT_SECRET_KEY = "!#@FDEWREWR&*("
+
 var (
 	// App settings.
 	AppVer  string
@@ -37,7 +40,8 @@ var (
 	MaxUploadSize int64
 
 	// Security settings.
-	SecretKey          = "!#@FDEWREWR&*("
+	SecretKey          = _DEFAULT_SECRET_KEY
+	HasSecretKey       bool // SECRET_KEY is set to other than the default one.
 	LogInRememberDays  = 7
 	CookieUserName     = "gopm_awesome"
 	CookieRememberName = "gopm_incredible"
@@ -45,34 +49,26 @@ var (
 	// Admin settings.
 	AccessToken string
 
+	// Private package settings.
+	PrivateAccessTokens []string
+
+	// Retention settings.
+	RetentionAccessTokens []string
+
+	// Cache settings.
+	RefCacheTTL       time.Duration
+	CacheMaxSize      int64 // Bytes, 0 means unlimited.
+	CacheLowWatermark int64 // Bytes that eviction brings usage under.
+	CacheMaxAge       time.Duration
+
 	// Global setting objects.
-	Cfg           *ini.File
-	ProdMode      bool
-	RootPathPairs = map[string]int{
-		"github.com":      3,
-		"code.google.com": 3,
-		"bitbucket.org":   3,
-		"git.oschina.net": 3,
-		"gitcafe.com":     3,
-		"launchpad.net":   2,
-		"golang.org":      3,
-	}
-	ExtensionPairs = map[string]string{
-		"github.com/":      ".zip",
-		"code.google.com/": ".zip",
-		"golang.org/":      ".zip",
-		"bitbucket.org/":   ".zip",
-		"git.oschina.net/": ".zip",
-		"gitcafe.com/":     ".tar",
-		"gopkg.in/":        ".zip",
-		"launchpad.net/":   ".tar.gz",
-	}
-	GithubCredentials string
-	PageSize          = 30
+	Cfg      *ini.File
+	ProdMode bool
+	PageSize = 30
 
-	// QiNiu settings.
-	BucketName string
-	BucketUrl  string
+	// GitHub settings.
+	GithubClientID     string
+	GithubClientSecret string
 )
 
 var Service struct {
@@ -111,14 +107,19 @@ func init() {
 
 	MaxUploadSize = Cfg.Section("server").Key("MAX_UPLOAD_SIZE").MustInt64(5)
 
-	GithubCredentials = "client_id=" + Cfg.Section("github").Key("CLIENT_ID").String() +
-		"&client_secret=" + Cfg.Section("github").Key("CLIENT_SECRET").String()
+	sec := Cfg.Section("cache")
+	RefCacheTTL = time.Duration(sec.Key("REF_TTL").MustInt(300)) * time.Second
+	CacheMaxSize = sec.Key("MAX_SIZE").MustInt64(0) << 20
+	CacheLowWatermark = CacheMaxSize * int64(sec.Key("LOW_WATERMARK").RangeInt(90, 1, 100)) / 100
+	CacheMaxAge = time.Duration(sec.Key("MAX_AGE").MustInt(90)) * 24 * time.Hour
 
-	// conf.UP_HOST = Cfg.Section("qiniu").Key("UP_HOST").MustString(conf.UP_HOST)
-	BucketName = Cfg.Section("qiniu").Key("BUCKET_NAME").String()
-	BucketUrl = Cfg.Section("qiniu").Key("BUCKET_URL").String()
-	// conf.ACCESS_KEY = Cfg.Section("qiniu").Key("ACCESS_KEY").String()
-	// conf.SECRET_KEY = Cfg.Section("qiniu").Key("SECRET_KEY").String()
+	GithubClientID = Cfg.Section("github").Key("CLIENT_ID").String()
+	GithubClientSecret = Cfg.Section("github").Key("CLIENT_SECRET").String()
 
 	AccessToken = Cfg.Section("admin").Key("ACCESS_TOKEN").String()
+
+	SecretKey = Cfg.Section("security").Key("SECRET_KEY").MustString(SecretKey)
+	HasSecretKey = SecretKey != _DEFAULT_SECRET_KEY
+	PrivateAccessTokens = Cfg.Section("security").Key("PRIVATE_ACCESS_TOKENS").Strings(",")
+	RetentionAccessTokens = Cfg.Section("security").Key("RETENTION_ACCESS_TOKENS").Strings(",")
 }
@@ -0,0 +1,64 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package singleflight provides a duplicate function call suppression mechanism.
+package singleflight
+
+import (
+	"errors"
+	"sync"
+)
+
+// call represents an in-flight or completed call.
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// Group represents a class of work where calls with same key are coalesced.
+type Group struct {
+	lock  sync.Mutex
+	calls map[string]*call
+}
+
+// Do executes and returns results of given function, making sure only one execution
+// is in-flight for a given key at a time. Duplicate callers wait for the original
+// to complete and receive same results, with shared set to true.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.lock.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.lock.Unlock()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+	// Error is kept for duplicate callers if function panics.
+	c := &call{err: errors.New("singleflight: call panicked")}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.lock.Unlock()
+
+	defer func() {
+		g.lock.Lock()
+		delete(g.calls, key)
+		g.lock.Unlock()
+		c.wg.Done()
+	}()
+
+	c.val, c.err = fn()
+	return c.val, c.err, false
+}
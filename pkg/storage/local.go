@@ -0,0 +1,102 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package storage
+
+import (
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// localStorage saves objects as files under a directory.
+type localStorage struct {
+	root string
+}
+
+// NewLocal returns a storage that saves objects under given directory.
+func NewLocal(root string) Storage {
+	return &localStorage{root}
+}
+
+// path returns path of file of given key, keys cannot escape root directory.
+func (s *localStorage) path(key string) string {
+	return path.Join(s.root, path.Clean("/"+key))
+}
+
+func (s *localStorage) Put(key string, r io.Reader, size int64) (err error) {
+	fpath := s.path(key)
+	if err = os.MkdirAll(path.Dir(fpath), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmpPath := fpath + ".tmp"
+	fw, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		fw.Close()
+		if err != nil {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err = io.Copy(fw, r); err != nil {
+		return err
+	} else if err = fw.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, fpath)
+}
+
+// Open returns the file itself, which can be seeked.
+func (s *localStorage) Open(key string) (io.ReadCloser, *ObjectInfo, error) {
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrNotExist
+		}
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, &ObjectInfo{fi.Size(), fi.ModTime()}, nil
+}
+
+func (s *localStorage) Stat(key string) (*ObjectInfo, error) {
+	fi, err := os.Stat(s.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotExist
+		}
+		return nil, err
+	}
+	return &ObjectInfo{fi.Size(), fi.ModTime()}, nil
+}
+
+func (s *localStorage) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStorage) SignedURL(key string, expires time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
@@ -0,0 +1,295 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	_S3_ALGORITHM        = "AWS4-HMAC-SHA256"
+	_S3_UNSIGNED_PAYLOAD = "UNSIGNED-PAYLOAD"
+	_S3_MAX_EXPIRES      = 7 * 24 * time.Hour
+	_S3_DIAL_TIMEOUT     = 10 * time.Second
+	_S3_RESPONSE_TIMEOUT = time.Minute
+)
+
+// s3Transport gives up on unreachable or stalled services. Client has no total
+// timeout since objects are streamed to clients for as long as they download.
+var s3Transport = &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	Dial:                  (&net.Dialer{Timeout: _S3_DIAL_TIMEOUT}).Dial,
+	TLSHandshakeTimeout:   _S3_DIAL_TIMEOUT,
+	ResponseHeaderTimeout: _S3_RESPONSE_TIMEOUT,
+}
+
+// s3Storage saves objects in a bucket of Amazon S3 or a compatible service
+// (e.g. MinIO), requests are signed with AWS Signature Version 4.
+type s3Storage struct {
+	scheme    string
+	host      string
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	prefix    string
+	pathStyle bool // Bucket is in path rather than host name, which MinIO requires.
+	client    *http.Client
+}
+
+// NewS3 returns a storage that saves objects in given bucket of S3-compatible
+// service at given endpoint, keys are prefixed with given prefix.
+func NewS3(endpoint, region, bucket, accessKey, secretKey, prefix string, pathStyle bool) (Storage, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %v", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid endpoint: %s", endpoint)
+	}
+
+	s := &s3Storage{
+		scheme:    u.Scheme,
+		host:      u.Host,
+		region:    region,
+		bucket:    bucket,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		prefix:    strings.Trim(prefix, "/"),
+		pathStyle: pathStyle,
+		client:    &http.Client{Transport: s3Transport},
+	}
+	if len(s.prefix) > 0 {
+		s.prefix += "/"
+	}
+	if !pathStyle {
+		s.host = bucket + "." + s.host
+	}
+	return s, nil
+}
+
+// uriEncode encodes given string as S3 requires, which is stricter than net/url.
+func uriEncode(s string, encodeSlash bool) string {
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			buf = append(buf, c)
+			continue
+		}
+		buf = append(buf, fmt.Sprintf("%%%02X", c)...)
+	}
+	return string(buf)
+}
+
+// canonicalQuery returns given query in canonical form, keys are sorted.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// objectPath returns escaped path of object of given key.
+func (s *s3Storage) objectPath(key string) string {
+	p := "/" + s.prefix + strings.TrimPrefix(key, "/")
+	if s.pathStyle {
+		p = "/" + s.bucket + p
+	}
+	return uriEncode(p, false)
+}
+
+// scope returns credential scope of given time.
+func (s *s3Storage) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature returns signature of given canonical request made at given time.
+func (s *s3Storage) signature(canonicalRequest string, t time.Time) string {
+	stringToSign := _S3_ALGORITHM + "\n" +
+		t.Format("20060102T150405Z") + "\n" +
+		s.scope(t) + "\n" +
+		sha256Hex(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// s3Error represents error response of S3.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do sends signed request of given method on object of given key,
+// response body must be closed by caller when error is nil.
+func (s *s3Storage) do(method, key string, body io.Reader, size int64) (*http.Response, error) {
+	objectPath := s.objectPath(key)
+	req, err := http.NewRequest(method, s.scheme+"://"+s.host+objectPath, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	t := time.Now().UTC()
+	req.Header.Set("X-Amz-Date", t.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", _S3_UNSIGNED_PAYLOAD)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := method + "\n" +
+		objectPath + "\n" +
+		"\n" +
+		"host:" + s.host + "\n" +
+		"x-amz-content-sha256:" + _S3_UNSIGNED_PAYLOAD + "\n" +
+		"x-amz-date:" + t.Format("20060102T150405Z") + "\n" +
+		"\n" +
+		signedHeaders + "\n" +
+		_S3_UNSIGNED_PAYLOAD
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		_S3_ALGORITHM, s.accessKey, s.scope(t), signedHeaders, s.signature(canonicalRequest, t)))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, ErrNotExist
+	}
+	// Response of HEAD has no body.
+	var e s3Error
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if xml.Unmarshal(data, &e) == nil && len(e.Code) > 0 {
+		return nil, fmt.Errorf("fail to %s object(%s): %s: %s", method, key, e.Code, e.Message)
+	}
+	return nil, fmt.Errorf("fail to %s object(%s): status code %d", method, key, resp.StatusCode)
+}
+
+// objectInfo returns object information in given response.
+func objectInfo(resp *http.Response) *ObjectInfo {
+	info := &ObjectInfo{Size: resp.ContentLength}
+	info.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info
+}
+
+func (s *s3Storage) Put(key string, r io.Reader, size int64) error {
+	resp, err := s.do("PUT", key, r, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Storage) Open(key string) (io.ReadCloser, *ObjectInfo, error) {
+	resp, err := s.do("GET", key, nil, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, objectInfo(resp), nil
+}
+
+func (s *s3Storage) Stat(key string) (*ObjectInfo, error) {
+	resp, err := s.do("HEAD", key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return objectInfo(resp), nil
+}
+
+func (s *s3Storage) Delete(key string) error {
+	resp, err := s.do("DELETE", key, nil, 0)
+	if err != nil {
+		if err == ErrNotExist {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// presign returns presigned URL of object of given key at given time.
+func (s *s3Storage) presign(key string, expires time.Duration, t time.Time) string {
+	if expires > _S3_MAX_EXPIRES {
+		expires = _S3_MAX_EXPIRES
+	}
+	t = t.UTC()
+	objectPath := s.objectPath(key)
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", _S3_ALGORITHM)
+	query.Set("X-Amz-Credential", s.accessKey+"/"+s.scope(t))
+	query.Set("X-Amz-Date", t.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+	canonicalRequest := "GET\n" +
+		objectPath + "\n" +
+		canonicalQuery(query) + "\n" +
+		"host:" + s.host + "\n" +
+		"\n" +
+		"host\n" +
+		_S3_UNSIGNED_PAYLOAD
+
+	return s.scheme + "://" + s.host + objectPath + "?" + canonicalQuery(query) +
+		"&X-Amz-Signature=" + s.signature(canonicalRequest, t)
+}
+
+func (s *s3Storage) SignedURL(key string, expires time.Duration) (string, error) {
+	return s.presign(key, expires, time.Now()), nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package storage implements backends that archives are saved in.
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/gpmgo/switch/pkg/log"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+var (
+	ErrNotExist     = errors.New("object does not exist")
+	ErrNotSupported = errors.New("operation is not supported by storage")
+)
+
+// Names of storage drivers.
+const (
+	LOCAL = "local"
+	S3    = "s3"
+)
+
+// ObjectInfo represents information of an object in storage.
+type ObjectInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage represents a backend that saves objects by keys,
+// keys are slash-separated relative paths.
+type Storage interface {
+	// Put saves content of given size read from r as object of given key.
+	Put(key string, r io.Reader, size int64) error
+	// Open returns content and information of object of given key,
+	// it returns ErrNotExist if the object does not exist.
+	Open(key string) (io.ReadCloser, *ObjectInfo, error)
+	// Stat returns information of object of given key,
+	// it returns ErrNotExist if the object does not exist.
+	Stat(key string) (*ObjectInfo, error)
+	// Delete deletes object of given key, it is not an error if the object does not exist.
+	Delete(key string) error
+	// SignedURL returns a URL that object of given key can be downloaded directly
+	// from before given duration passes, it returns ErrNotSupported if the storage
+	// is not accessible by clients.
+	SignedURL(key string, expires time.Duration) (string, error)
+}
+
+var (
+	drivers = make(map[string]Storage)
+
+	// Default is name of storage driver that new archives are saved in.
+	Default string
+	// Redirect indicates whether downloads redirect to signed URLs of
+	// storage that supports them, or stream objects through the server.
+	Redirect bool
+	// URLExpires is how long signed URLs are valid.
+	URLExpires time.Duration
+	// CopyMaxSize is the maximum total size in bytes of local copies of
+	// archives in other storage, which are made when archives have to be read.
+	CopyMaxSize int64
+	// CopyTTL is how long local copies are kept after last use.
+	CopyTTL time.Duration
+)
+
+// Get returns storage driver of given name, it returns nil if not configured.
+func Get(name string) Storage {
+	return drivers[name]
+}
+
+func init() {
+	drivers[LOCAL] = NewLocal(setting.ArchivePath)
+
+	sec := setting.Cfg.Section("storage.s3")
+	if len(sec.Key("BUCKET").String()) > 0 {
+		s, err := NewS3(sec.Key("ENDPOINT").MustString("https://s3.amazonaws.com"),
+			sec.Key("REGION").MustString("us-east-1"),
+			sec.Key("BUCKET").String(),
+			sec.Key("ACCESS_KEY").String(),
+			sec.Key("SECRET_KEY").String(),
+			sec.Key("PREFIX").String(),
+			sec.Key("PATH_STYLE").MustBool())
+		if err != nil {
+			log.Fatal(4, "Fail to create S3 storage: %v", err)
+		}
+		drivers[S3] = s
+		log.Trace("Storage registered: %s", S3)
+	}
+
+	sec = setting.Cfg.Section("storage")
+	Default = sec.Key("TYPE").In(LOCAL, []string{LOCAL, S3})
+	if Get(Default) == nil {
+		log.Fatal(4, "Storage '%s' is not configured", Default)
+	}
+	Redirect = sec.Key("REDIRECT").MustBool(true)
+	URLExpires = time.Duration(sec.Key("URL_EXPIRES").MustInt(900)) * time.Second
+	CopyMaxSize = sec.Key("COPY_MAX_SIZE").MustInt64(1024) << 20
+	CopyTTL = time.Duration(sec.Key("COPY_TTL").MustInt(60)) * time.Minute
+}
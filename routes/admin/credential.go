@@ -0,0 +1,75 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package admin
+
+import (
+	"github.com/gpmgo/switch/models"
+	"github.com/gpmgo/switch/pkg/archive"
+	"github.com/gpmgo/switch/pkg/base"
+	"github.com/gpmgo/switch/pkg/middleware"
+)
+
+func Credentials(ctx *middleware.Context) {
+	ctx.Data["PageIsCredentials"] = true
+
+	creds, err := models.ListCredentials()
+	if err != nil {
+		ctx.Handle(500, "ListCredentials", err)
+		return
+	}
+	ctx.Data["Credentials"] = creds
+
+	ctx.HTML(200, "credentials/list")
+}
+
+func NewCredential(ctx *middleware.Context) {
+	ctx.Data["PageIsCredentials"] = true
+	ctx.HTML(200, "credentials/new")
+}
+
+func NewCredentialPost(ctx *middleware.Context) {
+	ctx.Data["PageIsCredentials"] = true
+
+	c := &models.Credential{
+		Host:       ctx.Query("host"),
+		PathPrefix: ctx.Query("path_prefix"),
+		Type:       archive.CredentialType(ctx.Query("type")),
+		Username:   ctx.Query("username"),
+		Note:       ctx.Query("note"),
+	}
+	if err := models.NewCredential(c, ctx.Query("secret")); err != nil {
+		if err == models.ErrInvalidCredentialType || err == base.ErrSecretKeyNotSet {
+			ctx.RenderWithErr(err.Error(), "credentials/new", nil)
+		} else {
+			ctx.Handle(500, "NewCredential", err)
+		}
+		return
+	}
+
+	ctx.Flash.Success("New credential has been added!")
+	ctx.Redirect("/admin/credentials")
+}
+
+func DeleteCredential(ctx *middleware.Context) {
+	ctx.Data["PageIsCredentials"] = true
+
+	if err := models.DeleteCredential(ctx.ParamsInt64(":id")); err != nil {
+		ctx.Handle(500, "DeleteCredential", err)
+		return
+	}
+
+	ctx.Flash.Success("Credential has been deleted!")
+	ctx.Redirect("/admin/credentials")
+}
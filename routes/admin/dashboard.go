@@ -15,10 +15,43 @@
 package admin
 
 import (
+	"github.com/gpmgo/switch/models"
+	"github.com/gpmgo/switch/pkg/archive"
+	"github.com/gpmgo/switch/pkg/base"
 	"github.com/gpmgo/switch/pkg/middleware"
+	"github.com/gpmgo/switch/pkg/setting"
 )
 
 func Dashboard(ctx *middleware.Context) {
 	ctx.Data["PageIsDashboard"] = true
+	ctx.Data["RateLimits"] = archive.RateLimits()
+
+	drifts, err := models.ListTagDrifts(0, true)
+	if err != nil {
+		ctx.Handle(500, "ListTagDrifts", err)
+		return
+	}
+	ctx.Data["TagDrifts"] = drifts
+
+	stats, err := models.GetBlobStats()
+	if err != nil {
+		ctx.Handle(500, "GetBlobStats", err)
+		return
+	}
+	ctx.Data["BlobStats"] = stats
+	ctx.Data["BlobSize"] = base.FileSize(stats.Size)
+	ctx.Data["BlobRefSize"] = base.FileSize(stats.RefSize)
+	ctx.Data["BlobSavedSize"] = base.FileSize(stats.SavedSize)
+
+	usage, err := models.GetCacheUsage()
+	if err != nil {
+		ctx.Handle(500, "GetCacheUsage", err)
+		return
+	}
+	ctx.Data["CacheUsage"] = base.FileSize(usage)
+	if setting.CacheMaxSize > 0 {
+		ctx.Data["CacheMaxSize"] = base.FileSize(setting.CacheMaxSize)
+	}
+	ctx.Data["CacheStats"] = models.GetCacheStats()
 	ctx.HTML(200, "dashboard")
 }
@@ -16,15 +16,87 @@ package admin
 
 import (
 	"github.com/gpmgo/switch/models"
+	"github.com/gpmgo/switch/pkg/base"
 	"github.com/gpmgo/switch/pkg/middleware"
+	"github.com/gpmgo/switch/pkg/setting"
 )
 
+// RevisionInfo represents a revision with its retention status.
+type RevisionInfo struct {
+	*models.Revision
+	ArchiveSize string
+	Retention   *models.RetentionStatus
+}
+
 func Revisions(ctx *middleware.Context) {
 	ctx.Data["PageIsPackages"] = true
 	ctx.Data["PageIsPackagesList"] = true
+
+	page := ctx.QueryInt("page")
+	if page < 1 {
+		page = 1
+	}
+	revs, err := models.ListRevisions((page - 1) * setting.PageSize)
+	if err != nil {
+		ctx.Handle(500, "ListRevisions", err)
+		return
+	}
+
+	infos := make([]*RevisionInfo, len(revs))
+	for i, r := range revs {
+		status, err := models.GetRetentionStatus(r)
+		if err != nil {
+			ctx.Handle(500, "GetRetentionStatus", err)
+			return
+		}
+		infos[i] = &RevisionInfo{
+			Revision:    r,
+			ArchiveSize: base.FileSize(r.Size),
+			Retention:   status,
+		}
+	}
+	ctx.Data["Revisions"] = infos
+	ctx.Data["Page"] = page
+	ctx.Data["HasNextPage"] = len(revs) == setting.PageSize
+
 	ctx.HTML(200, "packages/list")
 }
 
+func setRevisionPinned(ctx *middleware.Context, pinned bool) {
+	r, err := models.GetRevisionByID(ctx.ParamsInt64(":id"))
+	if err != nil {
+		if err == models.ErrRevisionNotExist {
+			ctx.Handle(404, "GetRevisionByID", err)
+		} else {
+			ctx.Handle(500, "GetRevisionByID", err)
+		}
+		return
+	}
+	if err = models.SetRevisionPinned(r, pinned); err != nil {
+		ctx.Handle(500, "SetRevisionPinned", err)
+		return
+	}
+
+	if pinned {
+		ctx.Flash.Success("Revision has been pinned!")
+	} else {
+		ctx.Flash.Success("Revision has been unpinned!")
+	}
+	ctx.Redirect("/admin/packages")
+}
+
+func PinRevision(ctx *middleware.Context) {
+	ctx.Data["PageIsPackages"] = true
+	ctx.Data["PageIsPackagesList"] = true
+	setRevisionPinned(ctx, true)
+}
+
+func UnpinRevision(ctx *middleware.Context) {
+	ctx.Data["PageIsPackages"] = true
+	ctx.Data["PageIsPackagesList"] = true
+	setRevisionPinned(ctx, false)
+}
+
 func LargeRevisions(ctx *middleware.Context) {
 	ctx.Data["PageIsPackages"] = true
 	ctx.Data["PageIsPackagesLarges"] = true
@@ -0,0 +1,52 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package admin
+
+import (
+	"github.com/gpmgo/switch/models"
+	"github.com/gpmgo/switch/pkg/middleware"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+func Refs(ctx *middleware.Context) {
+	ctx.Data["PageIsRefs"] = true
+
+	page := ctx.QueryInt("page")
+	if page < 1 {
+		page = 1
+	}
+	refs, err := models.ListRefCaches((page - 1) * setting.PageSize)
+	if err != nil {
+		ctx.Handle(500, "ListRefCaches", err)
+		return
+	}
+	ctx.Data["Refs"] = refs
+	ctx.Data["Page"] = page
+	ctx.Data["HasNextPage"] = len(refs) == setting.PageSize
+
+	ctx.HTML(200, "refs/list")
+}
+
+func InvalidateRef(ctx *middleware.Context) {
+	ctx.Data["PageIsRefs"] = true
+
+	if err := models.DeleteRefCache(ctx.ParamsInt64(":id")); err != nil {
+		ctx.Handle(500, "DeleteRefCache", err)
+		return
+	}
+
+	ctx.Flash.Success("Cached reference has been invalidated!")
+	ctx.Redirect("/admin/refs")
+}
@@ -16,16 +16,10 @@ package admin
 
 import (
 	"fmt"
-	"os"
-	"path"
 	"time"
 
-	"github.com/Unknwon/com"
-
 	"github.com/gpmgo/switch/models"
-	"github.com/gpmgo/switch/pkg/archive"
 	"github.com/gpmgo/switch/pkg/middleware"
-	"github.com/gpmgo/switch/pkg/qiniu"
 	"github.com/gpmgo/switch/pkg/setting"
 )
 
@@ -43,6 +37,8 @@ type ApiPackage struct {
 	Created    time.Time `json:"created"`
 }
 
+// ListLargeRevisions lists revisions kept in local storage
+// because their archives are larger than MAX_UPLOAD_SIZE.
 func ListLargeRevisions(ctx *middleware.Context) {
 	revs, err := models.GetLocalRevisions()
 	if err != nil {
@@ -54,52 +50,28 @@ func ListLargeRevisions(ctx *middleware.Context) {
 
 	largeRevs := make([]*ApiRevesion, 0, len(revs)/2)
 	for _, rev := range revs {
-		pkg, err := models.GetPakcageByID(rev.PkgId)
-		if err != nil {
-			ctx.JSON(500, map[string]string{
-				"error": fmt.Sprintf("fail to get package by ID(%d): %v", rev.PkgId, err),
-			})
-			return
-		}
-
-		ext := archive.GetExtension(pkg.ImportPath)
-		localPath := path.Join(pkg.ImportPath, rev.Revision)
-		fpath := path.Join(setting.ArchivePath, localPath+ext)
-
-		if !com.IsFile(fpath) {
+		// Greater then MAX_UPLOAD_SIZE.
+		if rev.Size <= setting.MaxUploadSize<<20 {
 			continue
 		}
-
-		// Check archive size.
-		f, err := os.Open(fpath)
-		if err != nil {
+		if err = rev.GetPackage(); err != nil {
 			ctx.JSON(500, map[string]string{
-				"error": fmt.Sprintf("fail to open file(%s): %v", fpath, err),
+				"error": fmt.Sprintf("fail to get package by ID(%d): %v", rev.PkgID, err),
 			})
 			return
 		}
-		fi, err := f.Stat()
-		if err != nil {
-			ctx.JSON(500, map[string]string{
-				"error": fmt.Sprintf("fail to get file info(%s): %v", fpath, err),
-			})
-			return
-		}
-		// Greater then MAX_UPLOAD_SIZE.
-		if fi.Size() > setting.MaxUploadSize<<20 {
-			largeRevs = append(largeRevs, &ApiRevesion{
-				Id: rev.Id,
-				Package: &ApiPackage{
-					Id:         pkg.Id,
-					ImportPath: pkg.ImportPath,
-					Created:    pkg.Created,
-				},
-				Revision: rev.Revision,
-				Size:     fi.Size(),
-				Updated:  rev.Updated,
-			})
-			continue
-		}
+
+		largeRevs = append(largeRevs, &ApiRevesion{
+			Id: rev.ID,
+			Package: &ApiPackage{
+				Id:         rev.Pkg.ID,
+				ImportPath: rev.Pkg.ImportPath,
+				Created:    rev.Pkg.Created,
+			},
+			Revision: rev.Revision,
+			Size:     rev.Size,
+			Updated:  rev.Updated,
+		})
 	}
 
 	ctx.JSON(200, map[string]interface{}{
@@ -123,31 +95,8 @@ func BlockPackage(ctx *middleware.Context) {
 		return
 	}
 
-	revs, err := pkg.GetRevisions()
-	if err != nil {
-		ctx.JSON(500, map[string]string{
-			"error": fmt.Sprintf("fail to get package revisions by ID(%d): %v", id, err),
-		})
-		return
-	}
-
-	// Delete package archives.
-	ext := archive.GetExtension(pkg.ImportPath)
-	for _, rev := range revs {
-		switch rev.Storage {
-		case models.QINIU:
-			key := pkg.ImportPath + "-" + rev.Revision + ext
-			if err = qiniu.DeleteArchive(key); err != nil {
-				ctx.JSON(500, map[string]string{
-					"error": fmt.Sprintf("fail to delete archive(%s): %v", key, err),
-				})
-				return
-			}
-		}
-	}
-	os.RemoveAll(path.Join(setting.ArchivePath, pkg.ImportPath))
-
-	if err = models.BlockPackage(pkg, revs, ctx.Query("note")); err != nil {
+	// Archives are deleted from their storage along with the package.
+	if err = models.BlockPackage(pkg.ImportPath, ctx.Query("note")); err != nil {
 		ctx.JSON(500, map[string]string{
 			"error": fmt.Sprintf("fail to block package by ID(%d): %v", id, err),
 		})
@@ -0,0 +1,60 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package v1
+
+import (
+	"time"
+
+	"github.com/gpmgo/switch/models"
+	"github.com/gpmgo/switch/pkg/middleware"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+type tagDrift struct {
+	ImportPath  string    `json:"import_path"`
+	Tag         string    `json:"tag"`
+	OldRevision string    `json:"old_revision"`
+	NewRevision string    `json:"new_revision"`
+	IsDeleted   bool      `json:"is_deleted"`
+	Detected    time.Time `json:"detected"`
+}
+
+// TagDrifts lists events of cached tags being moved or deleted upstream.
+func TagDrifts(ctx *middleware.Context) {
+	page := ctx.QueryInt("page")
+	if page < 1 {
+		page = 1
+	}
+	drifts, err := models.ListTagDrifts((page-1)*setting.PageSize, ctx.IsPrivateAuthorized())
+	if err != nil {
+		ctx.JSON(500, map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	results := make([]*tagDrift, len(drifts))
+	for i, d := range drifts {
+		results[i] = &tagDrift{
+			ImportPath:  d.ImportPath,
+			Tag:         d.Tag,
+			OldRevision: d.OldRevision,
+			NewRevision: d.NewRevision,
+			IsDeleted:   d.IsDeleted(),
+			Detected:    d.Detected,
+		}
+	}
+	ctx.JSON(200, results)
+}
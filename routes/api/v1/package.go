@@ -16,14 +16,15 @@ package v1
 
 import (
 	"path"
+	"time"
 
 	"gopkg.in/macaron.v1"
 
 	"github.com/gpmgo/switch/models"
 	"github.com/gpmgo/switch/pkg/archive"
 	"github.com/gpmgo/switch/pkg/base"
+	"github.com/gpmgo/switch/pkg/log"
 	"github.com/gpmgo/switch/pkg/middleware"
-	"github.com/gpmgo/switch/pkg/setting"
 )
 
 func PackageFilter() macaron.Handler {
@@ -37,50 +38,263 @@ func PackageFilter() macaron.Handler {
 	}
 }
 
+// parseDate parses RFC 3339 time in "date" query, it returns zero time if not given.
+func parseDate(ctx *middleware.Context) (time.Time, bool) {
+	date := ctx.Query("date")
+	if len(date) == 0 {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		ctx.JSON(400, map[string]interface{}{
+			"error": "invalid date, must be in RFC 3339 format: " + date,
+		})
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// unprocessable responds error of resolving or fetching package, which is 503
+// when rate limit of upstream is exhausted so clients retry later.
+func unprocessable(ctx *middleware.Context, err error) {
+	status := 422
+	if ctx.RetryLater(err) {
+		status = 503
+	}
+	ctx.JSON(status, map[string]interface{}{
+		"error": err.Error(),
+	})
+}
+
 func Download(ctx *middleware.Context) {
 	importPath := archive.GetRootPath(ctx.Query("pkgname"))
 	rev := ctx.Query("revision")
-	r, err := models.CheckPkg(importPath, rev)
-	if err != nil {
-		ctx.JSON(422, map[string]interface{}{
+	date, ok := parseDate(ctx)
+	if !ok {
+		return
+	}
+	r, err := models.CheckPkgAccess(importPath, rev, date, ctx.IsPrivateAuthorized())
+	if err == models.ErrPackagePrivate {
+		ctx.JSON(403, map[string]interface{}{
 			"error": err.Error(),
 		})
 		return
+	} else if err != nil {
+		unprocessable(ctx, err)
+		return
+	}
+
+	importPath = r.Pkg.ImportPath
+	serveName := path.Base(importPath) + "-" + base.ShortSha(r.Revision) + archive.GetExtension(importPath)
+
+	// Only the requested sub-package directory is served.
+	var subPath string
+	if ctx.Query("subdir") == "1" {
+		if subdir := archive.GetSubdir(ctx.Query("pkgname")); len(subdir) > 0 {
+			if subPath, err = r.SubdirArchivePath(subdir); err != nil {
+				ctx.JSON(422, map[string]interface{}{
+					"error": err.Error(),
+				})
+				return
+			}
+			serveName = path.Base(subdir) + "-" + base.ShortSha(r.Revision) + ".zip"
+		}
 	}
 
-	if err = models.IncreasePackageDownloadCount(importPath); err != nil {
+	// Sub-package directory archives are derived and not validated by checksum.
+	var validators *middleware.ArchiveValidators
+	if len(subPath) == 0 {
+		validators = &middleware.ArchiveValidators{
+			Sha256:    r.Sha256,
+			ModTime:   r.Committed,
+			Immutable: archive.IsSHA(rev) && rev == r.Revision,
+			Private:   r.Pkg.IsPrivate,
+		}
+	}
+
+	// Revision is still in use when client already has it.
+	if err = models.AccessRevision(r); err != nil {
 		ctx.JSON(500, map[string]interface{}{
 			"error": err.Error(),
 		})
 		return
-	} else if err = models.AddDownloader(ctx.RemoteAddr()); err != nil {
-		ctx.JSON(500, map[string]interface{}{
-			"error": err.Error(),
-		})
+	} else if ctx.NotModified(validators) {
 		return
 	}
 
-	ext := archive.GetExtension(importPath)
-	serveName := path.Base(importPath) + "-" + base.ShortSha(r.Revision) + ext
-	switch r.Storage {
-	case models.LOCAL:
-		ctx.ServeFile(path.Join(setting.ArchivePath, importPath, r.Revision+ext), serveName)
-		// case models.QINIU:
-		// 	ctx.Redirect("http://" + setting.BucketUrl + "/" + importPath + "-" + r.Revision + ext)
+	if !ctx.IsResumedDownload() {
+		if err = models.IncreasePackageDownloadCount(importPath); err != nil {
+			ctx.JSON(500, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		} else if err = models.AddDownloader(ctx.RemoteAddr()); err != nil {
+			ctx.JSON(500, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	if len(subPath) > 0 {
+		ctx.ServeFile(subPath, serveName)
+		return
+	}
+
+	s, err := r.Driver()
+	if err == nil {
+		var key string
+		if key, err = r.Key(); err == nil {
+			err = ctx.ServeObject(s, key, serveName, validators)
+		}
+	}
+	if err != nil {
+		ctx.JSON(500, map[string]interface{}{
+			"error": err.Error(),
+		})
 	}
 }
 
 func GetRevision(ctx *middleware.Context) {
 	importPath := archive.GetRootPath(ctx.Query("pkgname"))
 	rev := ctx.Query("revision")
-	n := archive.NewNode(importPath, rev)
-	if err := n.GetRevision(); err != nil {
-		ctx.JSON(422, map[string]interface{}{
+	date, ok := parseDate(ctx)
+	if !ok {
+		return
+	}
+	if !ctx.IsPrivateAuthorized() {
+		private, err := models.IsPrivatePath(importPath)
+		if err != nil {
+			ctx.JSON(500, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		} else if private {
+			ctx.JSON(403, map[string]interface{}{
+				"error": models.ErrPackagePrivate.Error(),
+			})
+			return
+		}
+	}
+
+	n, err := models.NewNode(importPath, rev)
+	if err != nil {
+		ctx.JSON(500, map[string]interface{}{
 			"error": err.Error(),
 		})
 		return
 	}
-	ctx.JSON(200, map[string]interface{}{
-		"sha": n.Revision,
+	n.Date = date
+	if err = models.ResolveRevision(n); err != nil {
+		unprocessable(ctx, err)
+		return
+	}
+
+	pv, err := models.GetPseudoVersion(n)
+	if err != nil {
+		// Pseudo-version is optional information.
+		log.Warn("Fail to get pseudo-version of %s@%s: %v", n.ImportPath, n.Revision, err)
+	}
+
+	resp := map[string]interface{}{
+		"sha":            n.Revision,
+		"tag":            n.Tag,
+		"pseudo_version": pv,
+	}
+	if !n.Committed.IsZero() {
+		resp["committed"] = n.Committed.UTC().Format(time.RFC3339)
+	}
+	ctx.JSON(200, resp)
+}
+
+// revisionInfo returns API representation of given revision.
+func revisionInfo(r *models.Revision) map[string]interface{} {
+	committed := ""
+	if !r.Committed.IsZero() {
+		committed = r.Committed.UTC().Format(time.RFC3339)
+	}
+	return map[string]interface{}{
+		"sha":            r.Revision,
+		"tag":            r.Tag,
+		"committed":      committed,
+		"author":         r.Author,
+		"subject":        r.Subject,
+		"pseudo_version": r.PseudoVersion,
+		"refs":           r.RefNames(),
+		"size":           r.Size,
+		"sha256":         r.Sha256,
+		"pinned":         r.Pinned,
+	}
+}
+
+// ListRevisions lists cached revisions of a package with commit metadata,
+// sorted by commit time from newest.
+func ListRevisions(ctx *middleware.Context) {
+	pkg, err := models.GetPakcageByPath(archive.GetRootPath(ctx.Query("pkgname")))
+	if err == nil && pkg.IsPrivate && !ctx.IsPrivateAuthorized() {
+		err = models.ErrPackageNotExist
+	}
+	if err != nil {
+		status := 500
+		if err == models.ErrPackageNotExist {
+			status = 404
+		}
+		ctx.JSON(status, map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	revs, err := pkg.GetRevisions()
+	if err != nil {
+		ctx.JSON(500, map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	infos := make([]map[string]interface{}, len(revs))
+	for i := range revs {
+		infos[i] = revisionInfo(revs[i])
+	}
+	ctx.JSON(200, infos)
+}
+
+// setRevisionPinned sets whether a cached revision of a package is pinned,
+// which is only allowed to admin and clients present a retention access token.
+func setRevisionPinned(ctx *middleware.Context, pinned bool) {
+	if !ctx.IsRetentionAuthorized() {
+		ctx.JSON(403, map[string]interface{}{
+			"error": "admin or retention access token is required",
+		})
+		return
+	}
+
+	pkg, err := models.GetPakcageByPath(archive.GetRootPath(ctx.Query("pkgname")))
+	if err == nil {
+		var r *models.Revision
+		if r, err = models.GetRevision(pkg.ID, ctx.Query("revision")); err == nil {
+			if err = models.SetRevisionPinned(r, pinned); err == nil {
+				ctx.JSON(200, revisionInfo(r))
+				return
+			}
+		}
+	}
+
+	status := 500
+	if err == models.ErrPackageNotExist || err == models.ErrRevisionNotExist {
+		status = 404
+	}
+	ctx.JSON(status, map[string]interface{}{
+		"error": err.Error(),
 	})
 }
+
+// PinRevision pins a cached revision, pinned revisions never expire or get evicted.
+func PinRevision(ctx *middleware.Context) {
+	setRevisionPinned(ctx, true)
+}
+
+// UnpinRevision unpins a cached revision.
+func UnpinRevision(ctx *middleware.Context) {
+	setRevisionPinned(ctx, false)
+}
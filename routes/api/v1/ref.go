@@ -0,0 +1,118 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package v1
+
+import (
+	"sort"
+
+	"github.com/gpmgo/switch/models"
+	"github.com/gpmgo/switch/pkg/archive"
+	"github.com/gpmgo/switch/pkg/middleware"
+	"github.com/gpmgo/switch/pkg/semver"
+)
+
+const (
+	_REFS_PAGE_SIZE     = 100
+	_REFS_MAX_PAGE_SIZE = 1000
+)
+
+type ref struct {
+	Name string `json:"name"`
+	Sha  string `json:"sha"`
+}
+
+// paginateRefs returns references of given names in given page.
+func paginateRefs(names []string, shas map[string]string, page, pageSize int) []*ref {
+	start := (page - 1) * pageSize
+	if start > len(names) {
+		start = len(names)
+	}
+	end := start + pageSize
+	if end > len(names) {
+		end = len(names)
+	}
+
+	refs := make([]*ref, 0, end-start)
+	for _, name := range names[start:end] {
+		refs = append(refs, &ref{name, shas[name]})
+	}
+	return refs
+}
+
+// ListRefs lists branches and tags of a package, tags are sorted by semantic
+// version from highest to lowest. Both are paginated by "page" and "page_size".
+func ListRefs(ctx *middleware.Context) {
+	importPath := archive.GetRootPath(ctx.Query("pkgname"))
+
+	if !ctx.IsPrivateAuthorized() {
+		private, err := models.IsPrivatePath(importPath)
+		if err != nil {
+			ctx.JSON(500, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		} else if private {
+			ctx.JSON(403, map[string]interface{}{
+				"error": models.ErrPackagePrivate.Error(),
+			})
+			return
+		}
+	}
+
+	n, err := models.NewNode(importPath, "")
+	if err != nil {
+		ctx.JSON(500, map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	refs, err := models.ListRefs(n)
+	if err != nil {
+		unprocessable(ctx, err)
+		return
+	}
+
+	page := ctx.QueryInt("page")
+	if page < 1 {
+		page = 1
+	}
+	pageSize := ctx.QueryInt("page_size")
+	if pageSize < 1 {
+		pageSize = _REFS_PAGE_SIZE
+	} else if pageSize > _REFS_MAX_PAGE_SIZE {
+		pageSize = _REFS_MAX_PAGE_SIZE
+	}
+
+	branches := make([]string, 0, len(refs.Branches))
+	for name := range refs.Branches {
+		branches = append(branches, name)
+	}
+	sort.Strings(branches)
+	tags := make([]string, 0, len(refs.Tags))
+	for name := range refs.Tags {
+		tags = append(tags, name)
+	}
+	semver.Sort(tags)
+
+	ctx.JSON(200, map[string]interface{}{
+		"head":           refs.Head,
+		"branches":       paginateRefs(branches, refs.Branches, page, pageSize),
+		"tags":           paginateRefs(tags, refs.Tags, page, pageSize),
+		"total_branches": len(branches),
+		"total_tags":     len(tags),
+		"page":           page,
+		"page_size":      pageSize,
+	})
+}
@@ -16,52 +16,92 @@ package routes
 
 import (
 	"path"
+	"time"
 
 	"github.com/gpmgo/switch/models"
 	"github.com/gpmgo/switch/pkg/archive"
 	"github.com/gpmgo/switch/pkg/base"
 	"github.com/gpmgo/switch/pkg/middleware"
-	"github.com/gpmgo/switch/pkg/setting"
 )
 
 func Download(ctx *middleware.Context) {
 	ctx.Data["Title"] = ctx.Tr("download")
 	ctx.Data["PageIsDownload"] = true
 	importPath := archive.GetRootPath(ctx.Query("pkgname"))
+	isSubdir := ctx.Query("subdir") == "1"
 
 	if ctx.Req.Method == "POST" {
 		rev := ctx.Query("revision")
-		r, err := models.CheckPkg(importPath, rev)
+		r, err := models.CheckPkgAccess(importPath, rev, time.Time{}, ctx.IsPrivateAuthorized())
 		if err != nil {
 			ctx.Data["pkgname"] = importPath
 			ctx.Data["revision"] = rev
+			ctx.Data["subdir"] = isSubdir
 
 			errMsg := err.Error()
 			if err == archive.ErrNotMatchAnyService {
 				ctx.Data["Err_PkgName"] = true
 				errMsg = ctx.Tr("download.err_not_match_service")
+			} else if err == models.ErrPackagePrivate {
+				errMsg = ctx.Tr("download.err_package_private")
 			} else if _, ok := err.(*models.BlockError); ok {
 				errMsg = ctx.Tr("download.err_package_blocked", err.Error())
+			} else if ctx.RetryLater(err) {
+				ctx.Flash.ErrorMsg = ctx.Tr("download.err_rate_limited")
+				ctx.Data["Flash"] = ctx.Flash
+				ctx.HTML(503, "download")
+				return
 			}
 			ctx.RenderWithErr(errMsg, "download", nil)
 			return
 		}
 
+		importPath = r.Pkg.ImportPath
+		serveName := path.Base(importPath) + "-" + base.ShortSha(r.Revision) + archive.GetExtension(importPath)
+
+		// Only the requested sub-package directory is served.
+		var subPath string
+		if isSubdir {
+			if subdir := archive.GetSubdir(ctx.Query("pkgname")); len(subdir) > 0 {
+				if subPath, err = r.SubdirArchivePath(subdir); err != nil {
+					ctx.Data["pkgname"] = ctx.Query("pkgname")
+					ctx.Data["revision"] = rev
+					ctx.Data["subdir"] = isSubdir
+					ctx.RenderWithErr(ctx.Tr("download.err_subdir", err.Error()), "download", nil)
+					return
+				}
+				serveName = path.Base(subdir) + "-" + base.ShortSha(r.Revision) + ".zip"
+			}
+		}
+
 		if err = models.IncreasePackageDownloadCount(importPath); err != nil {
 			ctx.Handle(500, "IncreasePackageDownloadCount", err)
 			return
 		} else if err = models.AddDownloader(ctx.RemoteAddr()); err != nil {
 			ctx.Handle(500, "AddDownloader", err)
 			return
+		} else if err = models.AccessRevision(r); err != nil {
+			ctx.Handle(500, "AccessRevision", err)
+			return
+		}
+
+		if len(subPath) > 0 {
+			ctx.ServeFile(subPath, serveName)
+			return
 		}
 
-		ext := archive.GetExtension(importPath)
-		serveName := path.Base(importPath) + "-" + base.ShortSha(r.Revision) + ext
-		switch r.Storage {
-		case models.LOCAL:
-			ctx.ServeFile(path.Join(setting.ArchivePath, importPath, r.Revision+ext), serveName)
-			// case models.QINIU:
-			// 	ctx.Redirect("http://" + setting.BucketUrl + "/" + importPath + "-" + r.Revision + ext)
+		s, err := r.Driver()
+		if err != nil {
+			ctx.Handle(500, "Driver", err)
+			return
+		}
+		key, err := r.Key()
+		if err != nil {
+			ctx.Handle(500, "Key", err)
+			return
+		}
+		if err = ctx.ServeObject(s, key, serveName, nil); err != nil {
+			ctx.Handle(500, "ServeObject", err)
 		}
 		return
 	}
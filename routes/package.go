@@ -23,7 +23,10 @@ import (
 
 func Package(ctx *middleware.Context) {
 	importPath := ctx.Params("*")
-	_, err := models.GetPakcageByPath(importPath)
+	pkg, err := models.GetPakcageByPath(importPath)
+	if err == nil && pkg.IsPrivate && !ctx.IsPrivateAuthorized() {
+		err = models.ErrPackageNotExist
+	}
 	if err != nil {
 		if err == models.ErrPackageNotExist {
 			ctx.Handle(404, "Package", nil)
@@ -33,14 +36,24 @@ func Package(ctx *middleware.Context) {
 		return
 	}
 
+	revs, err := pkg.GetRevisions()
+	if err != nil {
+		ctx.Handle(500, "GetRevisions", err)
+		return
+	}
+
 	ctx.Data["Title"] = importPath
 	ctx.Data["ImportPath"] = importPath
+	ctx.Data["Revisions"] = revs
 	ctx.HTML(200, "package")
 }
 
 func Badge(ctx *middleware.Context) {
 	importPath := ctx.Params("*")
 	pkg, err := models.GetPakcageByPath(importPath)
+	if err == nil && pkg.IsPrivate && !ctx.IsPrivateAuthorized() {
+		err = models.ErrPackageNotExist
+	}
 	if err != nil {
 		if err == models.ErrPackageNotExist {
 			ctx.Error(404)
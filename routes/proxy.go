@@ -0,0 +1,321 @@
+// Copyright 2015 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package routes
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Unknwon/com"
+
+	"github.com/gpmgo/switch/models"
+	"github.com/gpmgo/switch/pkg/archive"
+	"github.com/gpmgo/switch/pkg/middleware"
+	"github.com/gpmgo/switch/pkg/module"
+	"github.com/gpmgo/switch/pkg/semver"
+	"github.com/gpmgo/switch/pkg/setting"
+)
+
+// moduleLocation represents where a Go module is in its repository.
+type moduleLocation struct {
+	Root      string // Import path of repository root.
+	Dir       string // Directory of module in repository, without major version suffix.
+	Major     string // Major version suffix of module path, e.g. "v2".
+	TagPrefix string // Prefix of version tags of module, e.g. "tools/".
+}
+
+// locateModule returns location of given module path in its repository.
+func locateModule(modPath string) *moduleLocation {
+	l := &moduleLocation{Root: archive.GetRootPath(modPath)}
+	rel := ""
+	if strings.HasPrefix(modPath, l.Root+"/") {
+		rel = modPath[len(l.Root)+1:]
+	}
+	l.Dir, l.Major = module.SplitPathMajor(rel)
+	if len(l.Dir) > 0 {
+		l.TagPrefix = l.Dir + "/"
+	}
+	return l
+}
+
+// dirs returns candidate directories of module in repository, a module with major
+// version suffix is either in a subdirectory named by it or in the directory without.
+func (l *moduleLocation) dirs() []string {
+	if len(l.Major) == 0 {
+		return []string{l.Dir}
+	}
+	return []string{path.Join(l.Dir, l.Major), l.Dir}
+}
+
+// moduleVersion represents a resolved version of a Go module.
+type moduleVersion struct {
+	Path        string
+	Dir         string // Directory of module in repository.
+	Version     string
+	Time        time.Time
+	Rev         *models.Revision
+	ArchivePath string
+}
+
+// resolveModuleVersion resolves given version query of module to a cached revision.
+func resolveModuleVersion(modPath, query string, authorized bool) (*moduleVersion, error) {
+	l := locateModule(modPath)
+
+	rev := query
+	switch {
+	case module.IsPseudoVersion(query):
+		rev = module.PseudoVersionRev(query)
+	case module.IsSemver(query):
+		ver := strings.TrimSuffix(query, "+incompatible")
+		if _, ok := module.TagVersion(l.TagPrefix+ver, l.TagPrefix, l.Major); !ok && ver == query {
+			return nil, fmt.Errorf("version %s is invalid for module %s", query, modPath)
+		}
+		rev = l.TagPrefix + ver
+	case query == "latest":
+		rev = ""
+	}
+
+	r, err := models.CheckPkgAccess(l.Root, rev, time.Time{}, authorized)
+	if err != nil {
+		return nil, err
+	}
+
+	mv := &moduleVersion{
+		Path: modPath,
+		Rev:  r,
+	}
+	// Archive in remote storage is copied to local to be read.
+	mv.ArchivePath, err = r.ArchivePath()
+	if err != nil {
+		return nil, fmt.Errorf("fail to get archive: %v", err)
+	}
+	mv.Dir, err = module.FindModuleDir(mv.ArchivePath, modPath, l.dirs()...)
+	if err != nil {
+		return nil, err
+	}
+	mv.Time, err = module.CommitTime(mv.ArchivePath)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get commit time: %v", err)
+	}
+
+	var tagged string
+	if !module.IsSemver(query) && !module.IsPseudoVersion(query) && len(r.PseudoVersion) == 0 {
+		// Revision of branch or commit may be tagged with a version of the module.
+		if tagged, err = taggedVersion(l, r.Revision); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case module.IsSemver(query):
+		mv.Version = query
+	case len(tagged) > 0:
+		mv.Version = tagged
+	case len(l.Dir) == 0 && len(l.Major) == 0 && len(r.PseudoVersion) > 0:
+		// Recorded pseudo-version is derived from tags of repository root module.
+		mv.Version = r.PseudoVersion
+	default:
+		mv.Version = module.PseudoVersionMajor(l.Major, mv.Time, r.Revision)
+	}
+	return mv, nil
+}
+
+// taggedVersion returns the highest version of module tagged on given revision,
+// or empty string if there is none.
+func taggedVersion(l *moduleLocation, rev string) (string, error) {
+	n, err := models.NewNode(l.Root, "")
+	if err != nil {
+		return "", err
+	}
+	refs, err := models.ListRefs(n)
+	if err != nil {
+		return "", err
+	}
+
+	vers := make([]string, 0, 1)
+	for tag, sha := range refs.Tags {
+		if sha != rev {
+			continue
+		}
+		if ver, ok := module.TagVersion(tag, l.TagPrefix, l.Major); ok {
+			vers = append(vers, ver)
+		}
+	}
+	if len(vers) == 0 {
+		return "", nil
+	}
+	semver.Sort(vers)
+	return vers[0], nil
+}
+
+// listModuleVersions returns canonical versions of module from tags of its repository,
+// in semantic version order from highest to lowest.
+func listModuleVersions(modPath string, authorized bool) ([]string, error) {
+	l := locateModule(modPath)
+	if !authorized {
+		private, err := models.IsPrivatePath(l.Root)
+		if err != nil {
+			return nil, err
+		} else if private {
+			return nil, models.ErrPackagePrivate
+		}
+	}
+
+	n, err := models.NewNode(l.Root, "")
+	if err != nil {
+		return nil, err
+	}
+	refs, err := models.ListRefs(n)
+	if err != nil {
+		return nil, err
+	}
+
+	vers := make([]string, 0, len(refs.Tags))
+	for tag := range refs.Tags {
+		if ver, ok := module.TagVersion(tag, l.TagPrefix, l.Major); ok {
+			vers = append(vers, ver)
+		}
+	}
+	semver.Sort(vers)
+	return vers, nil
+}
+
+func handleModuleError(ctx *middleware.Context, err error) {
+	if ctx.RetryLater(err) {
+		ctx.PlainText(503, []byte(err.Error()))
+		return
+	}
+
+	switch err.(type) {
+	case *models.BlockError:
+		ctx.PlainText(410, []byte(err.Error()))
+	default:
+		// Go command treats 404 and 410 as not found and reports the body.
+		ctx.PlainText(404, []byte(err.Error()))
+	}
+}
+
+// ModuleProxy serves Go module proxy protocol for GOPROXY clients.
+func ModuleProxy(ctx *middleware.Context) {
+	p := ctx.Params("*")
+
+	var escaped, action, escapedVer string
+	if i := strings.Index(p, "/@v/"); i > -1 {
+		escaped = p[:i]
+		action = p[i+len("/@v/"):]
+		if action != "list" {
+			ext := path.Ext(action)
+			escapedVer = strings.TrimSuffix(action, ext)
+			action = ext
+		}
+	} else if strings.HasSuffix(p, "/@latest") {
+		escaped = strings.TrimSuffix(p, "/@latest")
+		action = "latest"
+	} else {
+		ctx.PlainText(404, []byte("not found"))
+		return
+	}
+
+	modPath, err := module.UnescapePath(escaped)
+	if err != nil {
+		ctx.PlainText(404, []byte(err.Error()))
+		return
+	}
+	ver, err := module.UnescapeVersion(escapedVer)
+	if err != nil {
+		ctx.PlainText(404, []byte(err.Error()))
+		return
+	}
+
+	if (action == ".mod" || action == ".zip") && !module.IsSemver(ver) {
+		ctx.PlainText(404, []byte("not a canonical version: "+ver))
+		return
+	}
+
+	switch action {
+	case "list":
+		vers, err := listModuleVersions(modPath, ctx.IsPrivateAuthorized())
+		if err != nil {
+			handleModuleError(ctx, err)
+			return
+		}
+		ctx.PlainText(200, []byte(strings.Join(vers, "\n")))
+
+	case "latest", ".info":
+		if action == "latest" {
+			ver = "latest"
+		}
+		mv, err := resolveModuleVersion(modPath, ver, ctx.IsPrivateAuthorized())
+		if err != nil {
+			handleModuleError(ctx, err)
+			return
+		}
+		ctx.JSON(200, map[string]interface{}{
+			"Version": mv.Version,
+			"Time":    mv.Time.UTC().Format(time.RFC3339),
+		})
+
+	case ".mod":
+		mv, err := resolveModuleVersion(modPath, ver, ctx.IsPrivateAuthorized())
+		if err != nil {
+			handleModuleError(ctx, err)
+			return
+		}
+		data, err := module.GoMod(mv.ArchivePath, mv.Dir, modPath)
+		if err != nil {
+			ctx.PlainText(500, []byte(fmt.Sprintf("fail to read go.mod: %v", err)))
+			return
+		}
+		ctx.PlainText(200, data)
+
+	case ".zip":
+		mv, err := resolveModuleVersion(modPath, ver, ctx.IsPrivateAuthorized())
+		if err != nil {
+			handleModuleError(ctx, err)
+			return
+		}
+
+		// Modules in same repository are kept apart by their directories.
+		zipPath := path.Join(setting.ArchivePath, mv.Rev.Pkg.ImportPath, mv.Dir, "@v", ver+".zip")
+		if !com.IsFile(zipPath) {
+			if err = module.Repack(mv.ArchivePath, zipPath, mv.Dir, modPath, ver); err != nil {
+				ctx.PlainText(500, []byte(fmt.Sprintf("fail to repack archive: %v", err)))
+				return
+			}
+		} else {
+			// Repacked archives are evicted from least recently used.
+			now := time.Now()
+			os.Chtimes(zipPath, now, now)
+		}
+
+		if err = models.IncreasePackageDownloadCount(mv.Rev.Pkg.ImportPath); err != nil {
+			ctx.PlainText(500, []byte(err.Error()))
+			return
+		} else if err = models.AddDownloader(ctx.RemoteAddr()); err != nil {
+			ctx.PlainText(500, []byte(err.Error()))
+			return
+		} else if err = models.AccessRevision(mv.Rev); err != nil {
+			ctx.PlainText(500, []byte(err.Error()))
+			return
+		}
+		ctx.ServeFile(zipPath, ver+".zip")
+
+	default:
+		ctx.PlainText(404, []byte("not found"))
+	}
+}
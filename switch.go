@@ -70,6 +70,9 @@ func main() {
 	// m.Get("/search", routers.Search)
 	// m.Get("/about", routers.About)
 
+	// Go module proxy.
+	m.Get("/proxy/*", routes.ModuleProxy)
+
 	// Package.
 	m.Get("/*", routes.Package)
 	m.Get("/badge/*", routes.Badge)
@@ -81,6 +84,8 @@ func main() {
 
 		m.Group("/packages", func() {
 			m.Get("", admin.Revisions)
+			m.Get("/:id:int/pin", admin.PinRevision)
+			m.Get("/:id:int/unpin", admin.UnpinRevision)
 			m.Get("/larges", admin.LargeRevisions)
 		})
 
@@ -96,6 +101,17 @@ func main() {
 				m.Get("/:id:int/delete", admin.DeleteBlockRule)
 			})
 		})
+
+		m.Group("/refs", func() {
+			m.Get("", admin.Refs)
+			m.Get("/:id:int/delete", admin.InvalidateRef)
+		})
+
+		m.Group("/credentials", func() {
+			m.Get("", admin.Credentials)
+			m.Combo("/new").Get(admin.NewCredential).Post(admin.NewCredentialPost)
+			m.Get("/:id:int/delete", admin.DeleteCredential)
+		})
 	}, admin.Auth)
 
 	// API.
@@ -104,7 +120,12 @@ func main() {
 			m.Group("", func() {
 				m.Get("/download", v1.Download)
 				m.Get("/revision", v1.GetRevision)
+				m.Get("/refs", v1.ListRefs)
+				m.Get("/revisions", v1.ListRevisions)
+				m.Post("/pin", v1.PinRevision)
+				m.Post("/unpin", v1.UnpinRevision)
 			}, v1.PackageFilter())
+			m.Get("/tag-drifts", v1.TagDrifts)
 		})
 	})
 
@@ -112,7 +133,8 @@ func main() {
 	m.Get("/robots.txt", func() string {
 		return `User-agent: *
 Disallow: /api/
-Disallow: /download`
+Disallow: /download
+Disallow: /proxy/`
 	})
 
 	m.NotFound(routes.NotFound)